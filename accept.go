@@ -0,0 +1,166 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var formatPreferences atomic.Value
+
+func init() {
+	SetFormatPreferences(ImageTypeAVIF, ImageTypeWEBP)
+}
+
+// SetFormatPreferences sets the formats which are preferred by auto optimize,
+// the first is the most preferred, the default is avif, webp
+func SetFormatPreferences(formats ...string) {
+	formatPreferences.Store(append([]string{}, formats...))
+}
+
+func getFormatPreferences() []string {
+	formats, _ := formatPreferences.Load().([]string)
+	return formats
+}
+
+func isPreferredFormat(format string) bool {
+	for _, item := range getFormatPreferences() {
+		if item == format {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaRange is the media range of accept header, e.g. `image/webp;q=0.8`
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	result := make([]mediaRange, 0)
+	for _, item := range strings.Split(accept, ",") {
+		arr := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(arr[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range arr[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(kv[0]) != "q" {
+				continue
+			}
+			v, err := strconv.ParseFloat(kv[1], 64)
+			// 无效的q值视为不可接受
+			if err != nil || v < 0 {
+				v = 0
+			}
+			if v > 1 {
+				v = 1
+			}
+			q = v
+		}
+		result = append(result, mediaRange{
+			mediaType: mediaType,
+			q:         q,
+		})
+	}
+	return result
+}
+
+func formatMediaType(format string) string {
+	if strings.Contains(format, "/") {
+		return strings.ToLower(format)
+	}
+	return "image/" + strings.ToLower(format)
+}
+
+// acceptQuality returns the q value of format, the most specific media range is used,
+// e.g. `image/png` > `image/*` > `*/*`. If explicit is true, the wildcards are ignored.
+// It returns 0 if the format is not matched.
+func acceptQuality(ranges []mediaRange, format string, explicit bool) float64 {
+	mediaType := formatMediaType(format)
+	wildcard := mediaType[:strings.Index(mediaType, "/")] + "/*"
+	q := 0.0
+	specificity := 0
+	for _, r := range ranges {
+		current := 0
+		switch r.mediaType {
+		case mediaType:
+			current = 3
+		case wildcard:
+			current = 2
+		case "*/*":
+			current = 1
+		}
+		if current == 0 || (explicit && current != 3) {
+			continue
+		}
+		if current > specificity {
+			specificity = current
+			q = r.q
+		}
+	}
+	return q
+}
+
+// NegotiateFormat returns the format for the accept header, the format preferences are
+// chosen only if they are listed explicitly, because browsers send `image/*` without
+// supporting all the modern formats. The format with the highest q value is chosen,
+// and the earlier preference wins if the q values are equal.
+// The original format is returned if none of the preferences is acceptable and it is acceptable,
+// otherwise empty is returned(e.g. the original format is webp and its q value is 0).
+func NegotiateFormat(accept, format string) string {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return format
+	}
+	// 原格式的q值，为0则不可使用原格式
+	sourceQ := acceptQuality(ranges, format, isPreferredFormat(format))
+	result := ""
+	maxQ := 0.0
+	for _, preference := range getFormatPreferences() {
+		q := acceptQuality(ranges, preference, true)
+		if q > maxQ {
+			result = preference
+			maxQ = q
+		}
+	}
+	// 原格式的q值更高时使用原格式
+	if sourceQ > maxQ {
+		return format
+	}
+	return result
+}
+
+// isAcceptedFormat returns true if the format is accepted by the accept header,
+// the preferred formats should be listed explicitly, and all formats are accepted if the accept is empty
+func isAcceptedFormat(accept, format string) bool {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return true
+	}
+	return acceptQuality(ranges, format, isPreferredFormat(format)) > 0
+}
+
+// VaryAccept returns true if the image is negotiated by accept(auto optimize),
+// so the response should be set with `Vary: Accept`
+func (i *Image) VaryAccept() bool {
+	return i.varyAccept
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]mediaRange{
+		{mediaType: "image/avif", q: 1},
+		{mediaType: "image/webp", q: 0},
+		{mediaType: "image/*", q: 0.8},
+		{mediaType: "*/*", q: 0.5},
+		{mediaType: "image/png", q: 0},
+	}, parseAccept("image/avif, image/webp;q=0,image/*;q=0.8, */*; q=0.5,,image/png;q=abc"))
+}
+
+func TestAcceptQuality(t *testing.T) {
+	assert := assert.New(t)
+
+	ranges := parseAccept("image/webp;q=0.9,image/*;q=0.8,*/*;q=0.5,text/html")
+	assert.Equal(0.9, acceptQuality(ranges, ImageTypeWEBP, false))
+	assert.Equal(0.8, acceptQuality(ranges, ImageTypePNG, false))
+	assert.Equal(0.0, acceptQuality(ranges, ImageTypePNG, true))
+	assert.Equal(0.5, acceptQuality(ranges, "video/mp4", false))
+	assert.Equal(1.0, acceptQuality(ranges, "text/html", true))
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		accept string
+		format string
+		want   string
+	}{
+		{"", ImageTypePNG, ImageTypePNG},
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", ImageTypeJPEG, ImageTypeAVIF},
+		{"image/avif;q=0,image/webp,*/*", ImageTypeJPEG, ImageTypeWEBP},
+		{"image/avif;q=0.5,image/webp;q=0.8", ImageTypeJPEG, ImageTypeWEBP},
+		{"image/avif;q=0.5,image/jpeg", ImageTypeJPEG, ImageTypeJPEG},
+		// 通配符不会选择avif与webp
+		{"image/*,*/*;q=0.8", ImageTypePNG, ImageTypePNG},
+		{"image/webp,image/*", ImageTypeWEBP, ImageTypeWEBP},
+		// 原格式不可接受
+		{"text/html", ImageTypePNG, ""},
+		{"image/webp;q=0,image/*", ImageTypeWEBP, ""},
+		{"image/jpeg,image/png", ImageTypeWEBP, ""},
+		{"image/jpeg;q=0,image/*", ImageTypeJPEG, ""},
+		{"image/jpeg;q=0,image/webp", ImageTypeJPEG, ImageTypeWEBP},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, NegotiateFormat(tt.accept, tt.format), tt.accept)
+	}
+
+	SetFormatPreferences(ImageTypeWEBP, ImageTypeAVIF)
+	defer SetFormatPreferences(ImageTypeAVIF, ImageTypeWEBP)
+	assert.Equal(ImageTypeWEBP, NegotiateFormat("image/avif,image/webp", ImageTypeJPEG))
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	colorWhite = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	colorBlack = color.NRGBA{A: 255}
+)
+
+var maxCanvasSize int32 = 8192
+
+// SetMaxCanvasSize sets the max width and height of canvas created by pad, border, extend and montage,
+// the default is 8192
+func SetMaxCanvasSize(size int) {
+	atomic.StoreInt32(&maxCanvasSize, int32(size))
+}
+
+func getMaxCanvasSize() int {
+	return int(atomic.LoadInt32(&maxCanvasSize))
+}
+
+// checkCanvasSize checks the sizes of canvas, it returns ErrTooLarge if any size is larger than the max size
+func checkCanvasSize(sizes ...int) error {
+	limit := getMaxCanvasSize()
+	for _, size := range sizes {
+		if size > limit {
+			return wrapError(ErrTooLarge, fmt.Errorf("canvas is too large, size:%d, limit:%d", size, limit))
+		}
+	}
+	return nil
+}
+
+// NewPadImage creates a job, which pads the image with the color
+func NewPadImage(top, right, bottom, left int, c color.Color) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if top < 0 || right < 0 || bottom < 0 || left < 0 {
+			return nil, newInvalidParamError("pad size can not be negative")
+		}
+		// 先校验各尺寸，避免相加时溢出
+		if err := checkCanvasSize(top, right, bottom, left); err != nil {
+			return nil, err
+		}
+		width := img.Width() + left + right
+		height := img.Height() + top + bottom
+		if err := checkCanvasSize(width, height); err != nil {
+			return nil, err
+		}
+		canvas := imaging.New(width, height, c)
+		grid := imaging.Paste(canvas, img.grid, image.Pt(left, top))
+		return img.withAlphaGrid(grid), nil
+	}
+}
+
+// NewBorderImage creates a job, which adds a border to the image
+func NewBorderImage(width int, c color.Color) Job {
+	return NewPadImage(width, width, width, width, c)
+}
+
+// NewExtendImage creates a job, which extends the image to width/height,
+// and the image is placed at the position(same as watermark).
+// The size of image is kept if it is larger than width/height.
+func NewExtendImage(width, height int, position string, c color.Color) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		w := img.Width()
+		h := img.Height()
+		if w >= width && h >= height {
+			return img, nil
+		}
+		canvasWidth := width
+		if canvasWidth < w {
+			canvasWidth = w
+		}
+		canvasHeight := height
+		if canvasHeight < h {
+			canvasHeight = h
+		}
+		if err := checkCanvasSize(canvasWidth, canvasHeight); err != nil {
+			return nil, err
+		}
+		x, y := getWatermarkPosition(position, canvasWidth, canvasHeight, w, h)
+		canvas := imaging.New(canvasWidth, canvasHeight, c)
+		grid := imaging.Paste(canvas, img.grid, image.Pt(x, y))
+		return img.withAlphaGrid(grid), nil
+	}
+}
+
+// flatten draws the grid onto the background color
+func flatten(grid image.Image, c color.Color) *image.NRGBA {
+	bounds := grid.Bounds()
+	canvas := imaging.New(bounds.Dx(), bounds.Dy(), c)
+	return imaging.Overlay(canvas, grid, image.Pt(0, 0), 1)
+}
+
+// NewBackgroundImage creates a job, which flattens the alpha of image onto the color
+func NewBackgroundImage(c color.Color) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if !hasAlpha(img.grid) {
+			return img, nil
+		}
+		return img.WithGrid(flatten(img.grid, c)), nil
+	}
+}
+
+func parseIntParams(params []string, count int) ([]int, error) {
+	if len(params) < count {
+		return nil, errors.New("params are not enough")
+	}
+	result := make([]int, count)
+	for i := 0; i < count; i++ {
+		v, err := strconv.Atoi(params[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func parsePad(params []string, _ string) (Job, error) {
+	values, err := parseIntParams(params, 4)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseColorParam(params, 4, colorWhite)
+	if err != nil {
+		return nil, err
+	}
+	return NewPadImage(values[0], values[1], values[2], values[3], c), nil
+}
+
+func parseBorder(params []string, _ string) (Job, error) {
+	values, err := parseIntParams(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseColorParam(params, 1, colorBlack)
+	if err != nil {
+		return nil, err
+	}
+	return NewBorderImage(values[0], c), nil
+}
+
+func parseExtend(params []string, _ string) (Job, error) {
+	values, err := parseIntParams(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	position := PositionCenter
+	if len(params) > 2 && params[2] != "" {
+		position = params[2]
+	}
+	c, err := parseColorParam(params, 3, colorWhite)
+	if err != nil {
+		return nil, err
+	}
+	return NewExtendImage(values[0], values[1], position, c), nil
+}
+
+func parseBackground(params []string, _ string) (Job, error) {
+	c, err := parseColorParam(params, 0, colorWhite)
+	if err != nil {
+		return nil, err
+	}
+	return NewBackgroundImage(c), nil
+}
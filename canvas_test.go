@@ -0,0 +1,165 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func newColorImage(width, height int, c color.Color) *Image {
+	return &Image{
+		grid:   imaging.New(width, height, c),
+		format: ImageTypePNG,
+	}
+}
+
+func TestNewPadImage(t *testing.T) {
+	assert := assert.New(t)
+
+	red := color.NRGBA{R: 255, A: 255}
+	img := newColorImage(10, 10, colorBlack)
+	result, err := NewPadImage(1, 2, 3, 4, red)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(16, result.Width())
+	assert.Equal(14, result.Height())
+	grid := imaging.Clone(result.grid)
+	assert.Equal(red, grid.NRGBAAt(0, 0))
+	assert.Equal(colorBlack, grid.NRGBAAt(4, 1))
+	assert.Equal(colorBlack, grid.NRGBAAt(13, 10))
+	assert.Equal(red, grid.NRGBAAt(14, 10))
+	assert.Equal(red, grid.NRGBAAt(13, 11))
+
+	_, err = NewPadImage(-1, 0, 0, 0, red)(context.Background(), img)
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = NewPadImage(0, 8190, 0, 0, red)(context.Background(), img)
+	assert.True(errors.Is(err, ErrTooLarge))
+	_, err = NewPadImage(0, math.MaxInt, 0, math.MaxInt, red)(context.Background(), img)
+	assert.True(errors.Is(err, ErrTooLarge))
+	_, err = NewBorderImage(5000, red)(context.Background(), img)
+	assert.True(errors.Is(err, ErrTooLarge))
+
+	result, err = NewBorderImage(2, red)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(14, result.Width())
+	assert.Equal(14, result.Height())
+	grid = imaging.Clone(result.grid)
+	assert.Equal(red, grid.NRGBAAt(1, 1))
+	assert.Equal(colorBlack, grid.NRGBAAt(2, 2))
+}
+
+func TestNewExtendImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newColorImage(10, 10, colorBlack)
+	result, err := NewExtendImage(20, 8, PositionRight, colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(20, result.Width())
+	assert.Equal(10, result.Height())
+	grid := imaging.Clone(result.grid)
+	assert.Equal(colorWhite, grid.NRGBAAt(9, 0))
+	assert.Equal(colorBlack, grid.NRGBAAt(10, 0))
+
+	result, err = NewExtendImage(5, 5, PositionCenter, colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(img, result)
+
+	// 同一job处理不同图片时，尺寸不受前一图片影响
+	job := NewExtendImage(20, 8, PositionCenter, colorWhite)
+	result, err = job(context.Background(), newColorImage(30, 30, colorBlack))
+	assert.Nil(err)
+	assert.Equal(30, result.Width())
+	result, err = job(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(20, result.Width())
+	assert.Equal(10, result.Height())
+
+	_, err = NewExtendImage(10000, 10, PositionCenter, colorWhite)(context.Background(), img)
+	assert.True(errors.Is(err, ErrTooLarge))
+}
+
+func TestNewBackgroundImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newColorImage(10, 10, color.NRGBA{R: 255, A: 128})
+	result, err := NewBackgroundImage(colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.False(hasAlpha(result.grid))
+	assert.Equal(color.NRGBA{R: 255, G: 127, B: 127, A: 255}, imaging.Clone(result.grid).NRGBAAt(0, 0))
+
+	// 无透明像素则不处理
+	img = newColorImage(10, 10, colorBlack)
+	result, err = NewBackgroundImage(colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(img, result)
+}
+
+func TestEncodeJPEGWithAlpha(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newColorImage(10, 10, color.Transparent)
+	buf, err := img.JPEG()
+	assert.Nil(err)
+	grid, err := jpeg.Decode(bytes.NewReader(buf))
+	assert.Nil(err)
+	r, g, b, _ := grid.At(5, 5).RGBA()
+	// 透明像素以白色为背景
+	assert.True(r > 0xf000 && g > 0xf000 && b > 0xf000)
+}
+
+func TestParseCanvas(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("pad/1/2/3/4/red|border/2|extend/100/100/bottom/transparent|background/fff", "")
+	assert.Nil(err)
+	img, err := Do(context.Background(), newColorImage(10, 10, colorBlack), jobs...)
+	assert.Nil(err)
+	assert.Equal(image.Rect(0, 0, 100, 100), img.grid.Bounds())
+
+	for _, task := range []string{
+		"pad/1/2/3",
+		"pad/1/2/3/a",
+		"pad/1/2/3/4/unknown",
+		"border",
+		"extend/100",
+		"background/xyz",
+	} {
+		_, err = Parse(task, "")
+		assert.True(errors.Is(err, ErrInvalidParam), task)
+	}
+}
+
+func TestPadTransparent(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	result, err := NewPadImage(1, 1, 1, 1, color.Transparent)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(ImageTypePNG, result.format)
+
+	result, err = NewPadImage(1, 1, 1, 1, colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(ImageTypeJPEG, result.format)
+}
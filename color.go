@@ -0,0 +1,74 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"errors"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// ParseColor parses the color, it supports the hex color(rgb, rgba, rrggbb or rrggbbaa,
+// the prefix # or 0x is optional) and the css named color, e.g. `fff`, `#ff000080`, `white`, `transparent`
+func ParseColor(value string) (color.NRGBA, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "transparent" {
+		return color.NRGBA{}, nil
+	}
+	if c, ok := colornames.Map[value]; ok {
+		return color.NRGBA{
+			R: c.R,
+			G: c.G,
+			B: c.B,
+			A: c.A,
+		}, nil
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(value, "#"), "0x")
+	// 简写形式，如fff
+	if len(hex) == 3 || len(hex) == 4 {
+		arr := make([]byte, 0, len(hex)*2)
+		for i := 0; i < len(hex); i++ {
+			arr = append(arr, hex[i], hex[i])
+		}
+		hex = string(arr)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, errors.New("color is invalid: " + value)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, errors.New("color is invalid: " + value)
+	}
+	return color.NRGBA{
+		R: uint8(v >> 24),
+		G: uint8(v >> 16),
+		B: uint8(v >> 8),
+		A: uint8(v),
+	}, nil
+}
+
+// parseColorParam parses the color of params at index, the default color will be returned if it is not exists
+func parseColorParam(params []string, index int, defaultColor color.NRGBA) (color.NRGBA, error) {
+	if len(params) <= index || params[index] == "" {
+		return defaultColor, nil
+	}
+	return ParseColor(params[index])
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColor(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]color.NRGBA{
+		"fff":         {R: 255, G: 255, B: 255, A: 255},
+		"#f008":       {R: 255, A: 136},
+		"ff0000":      {R: 255, A: 255},
+		"0x00ff00":    {G: 255, A: 255},
+		"#0000FF80":   {B: 255, A: 128},
+		"white":       {R: 255, G: 255, B: 255, A: 255},
+		"Black":       {A: 255},
+		"transparent": {},
+		"lightgray":   {R: 211, G: 211, B: 211, A: 255},
+	} {
+		c, err := ParseColor(value)
+		assert.Nil(err, value)
+		assert.Equal(expected, c, value)
+	}
+
+	for _, value := range []string{
+		"",
+		"ff",
+		"fffff",
+		"gggggg",
+		"unknown",
+	} {
+		_, err := ParseColor(value)
+		assert.NotNil(err, value)
+	}
+
+	c, err := parseColorParam([]string{"10"}, 1, colorWhite)
+	assert.Nil(err)
+	assert.Equal(colorWhite, c)
+	c, err = parseColorParam([]string{"10", "red"}, 1, colorWhite)
+	assert.Nil(err)
+	assert.Equal(color.NRGBA{R: 255, A: 255}, c)
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	BlendNormal   = "normal"
+	BlendMultiply = "multiply"
+	BlendScreen   = "screen"
+	BlendOverlay  = "overlay"
+)
+
+// CompositeOptions is the options of composite
+type CompositeOptions struct {
+	// Position is the position of overlay image, default is top left
+	Position string
+	// X and Y are the offset of overlay image, which are added to the position
+	X int
+	Y int
+	// Mode is the blend mode, default is normal
+	Mode string
+	// Opacity is the opacity of overlay image(0-1), 0 means 1
+	Opacity float64
+}
+
+func blendChannel(mode string, cb, cs float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	}
+	return cs
+}
+
+// blendImage draws the overlay on the base image at pt with blend mode and opacity,
+// and returns a new image
+func blendImage(base, overlay image.Image, pt image.Point, mode string, opacity float64) *image.NRGBA {
+	dst := imaging.Clone(base)
+	src := imaging.Clone(overlay)
+	srcBounds := src.Bounds().Add(pt)
+	r := dst.Bounds().Intersect(srcBounds)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			di := dst.PixOffset(x, y)
+			si := src.PixOffset(x-pt.X, y-pt.Y)
+			as := float64(src.Pix[si+3]) / 255 * opacity
+			if as == 0 {
+				continue
+			}
+			ab := float64(dst.Pix[di+3]) / 255
+			ao := as + ab*(1-as)
+			for c := 0; c < 3; c++ {
+				cb := float64(dst.Pix[di+c]) / 255
+				cs := float64(src.Pix[si+c]) / 255
+				// 参考W3C compositing的公式，底图透明时使用原色
+				blended := (1-ab)*cs + ab*blendChannel(mode, cb, cs)
+				co := (as*blended + ab*cb*(1-as)) / ao
+				dst.Pix[di+c] = uint8(co*255 + 0.5)
+			}
+			dst.Pix[di+3] = uint8(ao*255 + 0.5)
+		}
+	}
+	return dst
+}
+
+// NewCompositeImage creates a job, which gets the overlay image from source job,
+// and composites it onto the image
+func NewCompositeImage(source Job, opts CompositeOptions) Job {
+	opacity := opts.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+	return func(ctx context.Context, img *Image) (*Image, error) {
+		overlay, err := source(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		if overlay == nil {
+			return nil, newInvalidParamError("composite source returns nil image")
+		}
+		overlayWidth := overlay.Width()
+		overlayHeight := overlay.Height()
+		x, y := getWatermarkPosition(opts.Position, img.Width(), img.Height(), overlayWidth, overlayHeight)
+		pt := image.Pt(x+opts.X, y+opts.Y)
+		grid := blendImage(img.grid, overlay.grid, pt, opts.Mode, opacity)
+		return img.WithGrid(grid), nil
+	}
+}
+
+// parseComposite parses the composite task,
+// e.g. `composite(badgeFinder/badge.png|fitResize/100/0)/bottomRight/-10/-10/multiply/0.8`
+func parseComposite(task, accept string) (Job, error) {
+	start := len(TaskComposite)
+	end := findCloseParen(task, start)
+	if end < 0 {
+		return nil, errors.New("source of composite task is invalid")
+	}
+	pipeline := task[start+1 : end]
+	if err := checkSourcePipeline(TaskComposite, pipeline); err != nil {
+		return nil, err
+	}
+	jobs, err := Parse(pipeline, accept)
+	if err != nil {
+		return nil, err
+	}
+	rest := task[end+1:]
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return nil, errors.New("composite task is invalid")
+	}
+	params := strings.Split(strings.TrimPrefix(rest, "/"), "/")
+	opts := CompositeOptions{
+		Position: PositionTopLeft,
+	}
+	if len(params) > 0 && params[0] != "" {
+		opts.Position = params[0]
+	}
+	if len(params) > 2 {
+		opts.X, err = strconv.Atoi(params[1])
+		if err != nil {
+			return nil, err
+		}
+		opts.Y, err = strconv.Atoi(params[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(params) > 3 {
+		opts.Mode = params[3]
+		switch opts.Mode {
+		case BlendNormal, BlendMultiply, BlendScreen, BlendOverlay:
+		default:
+			return nil, errors.New("blend mode is invalid: " + opts.Mode)
+		}
+	}
+	if len(params) > 4 {
+		opts.Opacity, err = strconv.ParseFloat(params[4], 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewCompositeImage(NewPipelineJob(jobs...), opts), nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlendImage(t *testing.T) {
+	assert := assert.New(t)
+
+	base := imaging.New(4, 4, color.NRGBA{R: 200, G: 100, B: 0, A: 255})
+	overlay := imaging.New(2, 2, color.NRGBA{R: 100, G: 100, B: 255, A: 255})
+
+	tests := []struct {
+		mode     string
+		opacity  float64
+		expected color.NRGBA
+	}{
+		{BlendNormal, 1, color.NRGBA{R: 100, G: 100, B: 255, A: 255}},
+		{BlendNormal, 0.5, color.NRGBA{R: 150, G: 100, B: 128, A: 255}},
+		{BlendMultiply, 1, color.NRGBA{R: 78, G: 39, B: 0, A: 255}},
+		{BlendScreen, 1, color.NRGBA{R: 222, G: 161, B: 255, A: 255}},
+		{BlendOverlay, 1, color.NRGBA{R: 188, G: 78, B: 0, A: 255}},
+	}
+	for _, tt := range tests {
+		result := blendImage(base, overlay, image.Pt(1, 1), tt.mode, tt.opacity)
+		assert.Equal(tt.expected, result.NRGBAAt(1, 1), tt.mode)
+		assert.Equal(tt.expected, result.NRGBAAt(2, 2), tt.mode)
+		// 不在覆盖区域的不受影响
+		assert.Equal(color.NRGBA{R: 200, G: 100, B: 0, A: 255}, result.NRGBAAt(0, 0), tt.mode)
+		assert.Equal(color.NRGBA{R: 200, G: 100, B: 0, A: 255}, result.NRGBAAt(3, 3), tt.mode)
+	}
+
+	// 透明的底图
+	result := blendImage(image.NewNRGBA(image.Rect(0, 0, 2, 2)), overlay, image.Pt(0, 0), BlendMultiply, 0.5)
+	assert.Equal(color.NRGBA{R: 100, G: 100, B: 255, A: 128}, result.NRGBAAt(0, 0))
+
+	// 超出底图范围
+	result = blendImage(base, overlay, image.Pt(3, 3), BlendNormal, 1)
+	assert.Equal(4, result.Bounds().Dx())
+	assert.Equal(color.NRGBA{R: 100, G: 100, B: 255, A: 255}, result.NRGBAAt(3, 3))
+}
+
+func TestNewCompositeImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	source := func(_ context.Context, _ *Image) (*Image, error) {
+		return &Image{
+			grid: imaging.New(10, 10, color.NRGBA{R: 255, A: 255}),
+		}, nil
+	}
+	result, err := NewCompositeImage(source, CompositeOptions{
+		Position: PositionBottomRight,
+		X:        -5,
+		Y:        -5,
+	})(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(829, result.Width())
+	assert.Equal(846, result.Height())
+	grid := imaging.Clone(result.grid)
+	assert.Equal(color.NRGBA{R: 255, A: 255}, grid.NRGBAAt(829-6, 846-6))
+	assert.Equal(color.NRGBA{R: 255, A: 255}, grid.NRGBAAt(829-15, 846-15))
+	assert.NotEqual(color.NRGBA{R: 255, A: 255}, grid.NRGBAAt(829-3, 846-3))
+
+	sourceErr := wrapError(ErrNotFound, errors.New("badge is not found"))
+	_, err = NewCompositeImage(func(_ context.Context, _ *Image) (*Image, error) {
+		return nil, sourceErr
+	}, CompositeOptions{})(context.Background(), img)
+	assert.Equal(sourceErr, err)
+
+	_, err = NewCompositeImage(func(_ context.Context, _ *Image) (*Image, error) {
+		return nil, nil
+	}, CompositeOptions{})(context.Background(), img)
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
+
+func TestParseComposite(t *testing.T) {
+	assert := assert.New(t)
+
+	basePath, err := ioutil.TempDir("", "badge")
+	assert.Nil(err)
+	defer os.RemoveAll(basePath)
+	err = ioutil.WriteFile(filepath.Join(basePath, "badge.jpg"), newImageData(), 0600)
+	assert.Nil(err)
+	assert.Nil(AddFileFinder("badgeFinder", basePath))
+
+	jobs, err := Parse("badgeFinder/badge.jpg|composite(badgeFinder/badge.jpg|fitResize/100/0)/bottomRight/-10/-10/multiply/0.8", "")
+	assert.Nil(err)
+	img, err := Do(context.Background(), nil, jobs...)
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+	assert.Equal(846, img.Height())
+
+	_, err = Parse("composite(badgeFinder/badge.jpg)", "")
+	assert.Nil(err)
+
+	for _, task := range []string{
+		"composite(badgeFinder/badge.jpg",
+		"composite(badgeFinder/badge.jpg)abc",
+		"composite(badgeFinder/badge.jpg)/center/a/0",
+		"composite(badgeFinder/badge.jpg)/center/0/0/darken",
+		"composite(badgeFinder/badge.jpg)/center/0/0/normal/abc",
+		// 首个任务需要获取图片
+		"composite(fitResize/10/10)",
+		"composite(trim|badgeFinder/badge.jpg)",
+	} {
+		_, err = Parse(task, "")
+		assert.True(errors.Is(err, ErrInvalidParam), task)
+	}
+}
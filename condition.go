@@ -0,0 +1,226 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Condition returns true if the image matches the condition
+type Condition func(img *Image) bool
+
+const (
+	ConditionWidth  = "width"
+	ConditionHeight = "height"
+	ConditionSize   = "size"
+	ConditionFormat = "format"
+	ConditionAlpha  = "alpha"
+	ConditionAccept = "accept"
+)
+
+// 需要注意两个字符的操作符需要在前
+var conditionOperators = []string{
+	">=",
+	"<=",
+	"==",
+	"!=",
+	">",
+	"<",
+}
+
+// NewConditionJob creates a job, which runs the job only if the image matches the condition,
+// otherwise the image will be returned directly
+func NewConditionJob(cond Condition, job Job) Job {
+	return func(ctx context.Context, img *Image) (*Image, error) {
+		if img == nil || !cond(img) {
+			return img, nil
+		}
+		return job(ctx, img)
+	}
+}
+
+func parseByteSize(value string) (int, error) {
+	value = strings.TrimSuffix(strings.ToLower(value), "b")
+	unit := 1
+	if strings.HasSuffix(value, "k") {
+		unit = 1024
+	} else if strings.HasSuffix(value, "m") {
+		unit = 1024 * 1024
+	}
+	value = strings.TrimRight(value, "km")
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	return size * unit, nil
+}
+
+func compareInt(operator string, a, b int) bool {
+	switch operator {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case ">":
+		return a > b
+	default:
+		return a < b
+	}
+}
+
+// acceptFormat returns true if the format is listed in accept explicitly and q value is not 0
+func acceptFormat(accept, format string) bool {
+	return acceptQuality(parseAccept(accept), format, true) > 0
+}
+
+func parseConditionTerm(term, accept string) (Condition, error) {
+	if term == ConditionAlpha {
+		return func(img *Image) bool {
+			return hasAlpha(img.grid)
+		}, nil
+	}
+	if term == "!"+ConditionAlpha {
+		return func(img *Image) bool {
+			return !hasAlpha(img.grid)
+		}, nil
+	}
+	operator := ""
+	index := -1
+	for _, op := range conditionOperators {
+		index = strings.Index(term, op)
+		if index > 0 {
+			operator = op
+			break
+		}
+	}
+	if operator == "" {
+		return nil, errors.New("condition is invalid: " + term)
+	}
+	field := term[:index]
+	value := term[index+len(operator):]
+	switch field {
+	case ConditionWidth, ConditionHeight, ConditionSize:
+		var v int
+		var err error
+		if field == ConditionSize {
+			v, err = parseByteSize(value)
+		} else {
+			v, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(img *Image) bool {
+			current := img.Width()
+			switch field {
+			case ConditionHeight:
+				current = img.Height()
+			case ConditionSize:
+				current = img.Size()
+			}
+			return compareInt(operator, current, v)
+		}, nil
+	case ConditionFormat, ConditionAccept:
+		if operator != "==" && operator != "!=" {
+			return nil, errors.New(field + " condition only supports == and !=")
+		}
+		expected := operator == "=="
+		if field == ConditionAccept {
+			// accept在解析时已确定
+			matched := acceptFormat(accept, value) == expected
+			return func(_ *Image) bool {
+				return matched
+			}, nil
+		}
+		return func(img *Image) bool {
+			return (img.format == value) == expected
+		}, nil
+	}
+	return nil, errors.New("condition field is invalid: " + field)
+}
+
+// ParseCondition parses the condition expression, the terms can be joined with && or ||,
+// and && has higher precedence, e.g. `width>2000`, `format==png&&!alpha`, `accept==webp`
+func ParseCondition(expr, accept string) (Condition, error) {
+	orConditions := make([]Condition, 0)
+	for _, item := range strings.Split(expr, "||") {
+		andConditions := make([]Condition, 0)
+		for _, term := range strings.Split(item, "&&") {
+			cond, err := parseConditionTerm(strings.TrimSpace(term), accept)
+			if err != nil {
+				return nil, err
+			}
+			andConditions = append(andConditions, cond)
+		}
+		orConditions = append(orConditions, func(img *Image) bool {
+			for _, cond := range andConditions {
+				if !cond(img) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return func(img *Image) bool {
+		for _, cond := range orConditions {
+			if cond(img) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// findCloseParen returns the index of the paren which closes the paren at start
+func findCloseParen(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseIf parses the condition task, e.g. `if(width>2000)fitResize/2000/0`
+func parseIf(task, accept string) (Job, error) {
+	start := len(TaskIf)
+	end := findCloseParen(task, start)
+	if end < 0 {
+		return nil, errors.New("condition of if task is invalid")
+	}
+	cond, err := ParseCondition(task[start+1:end], accept)
+	if err != nil {
+		return nil, err
+	}
+	_, job, err := parseTask(task[end+1:], accept)
+	if err != nil {
+		return nil, err
+	}
+	return NewConditionJob(cond, job), nil
+}
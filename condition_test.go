@@ -0,0 +1,130 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	assert := assert.New(t)
+
+	for value, expected := range map[string]int{
+		"100":   100,
+		"2k":    2048,
+		"2KB":   2048,
+		"1m":    1024 * 1024,
+		"3mb":   3 * 1024 * 1024,
+		"1024b": 1024,
+	} {
+		size, err := parseByteSize(value)
+		assert.Nil(err)
+		assert.Equal(expected, size, value)
+	}
+	_, err := parseByteSize("abc")
+	assert.NotNil(err)
+}
+
+func TestParseCondition(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	// 829x846 jpeg
+	transparentImg := &Image{
+		grid:   image.NewNRGBA(image.Rect(0, 0, 10, 10)),
+		format: ImageTypePNG,
+	}
+
+	accept := "image/avif,image/webp,*/*"
+	tests := []struct {
+		expr     string
+		img      *Image
+		expected bool
+	}{
+		{"width>800", img, true},
+		{"width>=829", img, true},
+		{"width>829", img, false},
+		{"height<900", img, true},
+		{"height<=845", img, false},
+		{"width==829", img, true},
+		{"width!=829", img, false},
+		{"size>10k", img, true},
+		{"size<10k", img, false},
+		{"format==jpeg", img, true},
+		{"format!=jpeg", img, false},
+		{"alpha", img, false},
+		{"!alpha", img, true},
+		{"alpha", transparentImg, true},
+		{"format==png&&!alpha", transparentImg, false},
+		{"format==png&&alpha", transparentImg, true},
+		{"width>400&&height>400", img, true},
+		{"width>400&&height>400", transparentImg, false},
+		{"width>1000||format==jpeg", img, true},
+		{"width>1000||format==png", img, false},
+		{"accept==webp", img, true},
+		{"accept==image/avif", img, true},
+		{"accept==jpeg", img, false},
+		{"accept!=jpeg", img, true},
+	}
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.expr, accept)
+		assert.Nil(err, tt.expr)
+		assert.Equal(tt.expected, cond(tt.img), tt.expr)
+	}
+
+	for _, expr := range []string{
+		"width",
+		"width>abc",
+		"color==red",
+		"format>png",
+	} {
+		_, err := ParseCondition(expr, accept)
+		assert.NotNil(err, expr)
+	}
+}
+
+func TestParseIf(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("if(width>800)fitResize/400/0|if(width>800)fillResize/100/100", "")
+	assert.Nil(err)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, report, err := DoWithReport(context.Background(), img, jobs...)
+	assert.Nil(err)
+	// 第二个任务由于宽度已调整为400，因此不执行
+	assert.Equal(400, img.Width())
+	assert.Equal(408, img.Height())
+	assert.Equal(TaskIf, report.Steps[0].Name)
+
+	// 嵌套条件
+	jobs, err = Parse("if(width>800)if(format==png)fitResize/400/0", "")
+	assert.Nil(err)
+	img, err = NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = Do(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+
+	_, err = Parse("if(width>800fitResize/400/0", "")
+	assert.NotNil(err)
+	_, err = Parse("if(width)fitResize/400/0", "")
+	assert.NotNil(err)
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// 颜色数不超过此值的图片才可能为平面图形，可无损转换为PNG-8
+const maxGraphicColors = 256
+
+// 平面图形以少数颜色为主，颜色分布的熵(bit)超过此值的视为照片类(如灰度照片)
+const maxGraphicEntropy = 4.0
+
+// contentInfo is the content analysis result of image
+type contentInfo struct {
+	alpha bool
+	// palette is the colors of image, it is nil if the image is not a flat graphic
+	palette color.Palette
+}
+
+// nrgbaAt returns the non-premultiplied color of pixel
+func nrgbaAt(grid image.Image, x, y int) color.NRGBA {
+	if src, ok := grid.(*image.NRGBA); ok {
+		return src.NRGBAAt(x, y)
+	}
+	return color.NRGBAModel.Convert(grid.At(x, y)).(color.NRGBA)
+}
+
+// colorEntropy returns the shannon entropy of the color distribution
+func colorEntropy(counts map[color.NRGBA]int, total int) float64 {
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// analyzeContent analyzes the grid, and gets the colors if the image is a flat graphic,
+// which has no more than 256 colors and few of them are dominant
+func analyzeContent(grid image.Image) contentInfo {
+	info := contentInfo{
+		alpha: hasAlpha(grid),
+	}
+	bounds := grid.Bounds()
+	counts := make(map[color.NRGBA]int)
+	palette := make(color.Palette, 0)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgbaAt(grid, x, y)
+			if _, ok := counts[c]; ok {
+				counts[c]++
+				continue
+			}
+			// 颜色过多，图片为照片类
+			if len(counts) >= maxGraphicColors {
+				return info
+			}
+			counts[c] = 1
+			palette = append(palette, c)
+		}
+	}
+	if len(palette) == 0 || colorEntropy(counts, bounds.Dx()*bounds.Dy()) > maxGraphicEntropy {
+		return info
+	}
+	info.palette = palette
+	return info
+}
+
+// selectContentFormat selects the format by the content of image:
+// flat graphics are converted to PNG-8, the transparent images keep alpha-capable format
+// if it is accepted(otherwise png), and the photographic images use jpeg
+func selectContentFormat(img *Image, accept string) (*Image, string) {
+	info := analyzeContent(img.grid)
+	if len(info.palette) != 0 {
+		grid := image.NewPaletted(img.grid.Bounds(), info.palette)
+		draw.Draw(grid, grid.Bounds(), img.grid, img.grid.Bounds().Min, draw.Src)
+		result := img.WithGrid(grid)
+		result.format = ImageTypePNG
+		return result, ImageTypePNG
+	}
+	if info.alpha {
+		format := alphaFormat(img.format)
+		if !isAcceptedFormat(accept, format) {
+			format = ImageTypePNG
+		}
+		return img, format
+	}
+	return img, ImageTypeJPEG
+}
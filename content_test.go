@@ -0,0 +1,157 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+// newGradientImage returns an image with many colors and the alpha
+func newGradientImage(width, height int, alpha uint8) *Image {
+	grid := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			grid.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x),
+				G: uint8(y),
+				B: uint8(x + y),
+				A: alpha,
+			})
+		}
+	}
+	return &Image{
+		grid:   grid,
+		format: ImageTypePNG,
+	}
+}
+
+func TestAnalyzeContent(t *testing.T) {
+	assert := assert.New(t)
+
+	info := analyzeContent(newColorImage(10, 10, colorWhite).grid)
+	assert.False(info.alpha)
+	assert.Equal(color.Palette{colorWhite}, info.palette)
+
+	info = analyzeContent(newGradientImage(100, 100, 128).grid)
+	assert.True(info.alpha)
+	assert.Nil(info.palette)
+	// 灰度照片虽然颜色不超过256，但各灰度分布均匀，不视为平面图形
+	gray := image.NewGray(image.Rect(0, 0, 256, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 256; x++ {
+			gray.SetGray(x, y, color.Gray{Y: uint8(x + y)})
+		}
+	}
+	info = analyzeContent(gray)
+	assert.False(info.alpha)
+	assert.Nil(info.palette)
+	_, format := selectContentFormat(&Image{
+		grid:   gray,
+		format: ImageTypeJPEG,
+	}, "")
+	assert.Equal(ImageTypeJPEG, format)
+
+	// 以少数颜色为主的图形(如带抗锯齿的图标)
+	graphic := image.NewGray(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			v := uint8(255)
+			if x >= 40 && x < 60 {
+				v = 0
+			} else if x == 39 || x == 60 {
+				v = uint8(y)
+			}
+			graphic.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	info = analyzeContent(graphic)
+	assert.Equal(101, len(info.palette))
+}
+
+func TestSelectContentFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	// 平面图形转换为PNG-8
+	graphic := newStripeImage(20, 20)
+	result, format := selectContentFormat(graphic, "")
+	assert.Equal(ImageTypePNG, format)
+	paletted, ok := result.grid.(*image.Paletted)
+	assert.True(ok)
+	// 颜色无损
+	assert.Equal(imaging.Clone(graphic.grid), imaging.Clone(paletted))
+
+	// 透明图片保留支持透明的格式
+	transparent := newGradientImage(100, 100, 128)
+	result, format = selectContentFormat(transparent, "")
+	assert.Equal(ImageTypePNG, format)
+	assert.Equal(transparent, result)
+	transparent.format = ImageTypeJPEG
+	_, format = selectContentFormat(transparent, "")
+	assert.Equal(ImageTypePNG, format)
+	// 客户端不支持时不保留webp
+	transparent.format = ImageTypeWEBP
+	_, format = selectContentFormat(transparent, "")
+	assert.Equal(ImageTypeWEBP, format)
+	_, format = selectContentFormat(transparent, "image/webp;q=0,image/*")
+	assert.Equal(ImageTypePNG, format)
+
+	// 照片类使用jpeg
+	_, format = selectContentFormat(newGradientImage(100, 100, 255), "")
+	assert.Equal(ImageTypeJPEG, format)
+}
+
+func TestAutoOptimizeContentFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	optimizer, _ := newTestOptimServer(t)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	data, err := newGradientImage(100, 100, 255).PNG()
+	assert.Nil(err)
+	photo, err := NewImageFromBytes(data)
+	assert.Nil(err)
+
+	fn := NewAutoOptimizeImage(optimizer, 80, "image/*")
+	result, err := fn(context.Background(), photo)
+	assert.Nil(err)
+	assert.Equal(ImageTypeJPEG, result.format)
+
+	result, err = fn(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(ImageTypeJPEG, result.format)
+
+	// 支持webp时不根据内容选择
+	webp := *photo
+	webp.format = ImageTypeWEBP
+	result, err = NewAutoOptimizeImage(optimizer, 80, "image/webp")(context.Background(), &webp)
+	assert.Nil(err)
+	assert.Equal(ImageTypeWEBP, result.format)
+
+	// 不支持webp时根据内容选择
+	for _, accept := range []string{
+		"image/webp;q=0,image/*",
+		"image/jpeg,image/png",
+	} {
+		result, err = NewAutoOptimizeImage(optimizer, 80, accept)(context.Background(), &webp)
+		assert.Nil(err)
+		assert.Equal(ImageTypeJPEG, result.format, accept)
+	}
+}
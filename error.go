@@ -0,0 +1,131 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// The categories of error, use errors.Is to check the category
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidParam      = errors.New("invalid param")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrUpstream          = errors.New("upstream error")
+	ErrTooLarge          = errors.New("too large")
+	ErrForbidden         = errors.New("forbidden")
+)
+
+// Error is the error of pipeline, it wraps the cause with category and task
+type Error struct {
+	// Category is the category of error, such as ErrNotFound, it may be nil
+	Category error
+	// Index is the index of failing task in the top-level pipeline, it is -1 if the task is unknown
+	Index int
+	// Task is the name of failing task in the top-level pipeline
+	Task string
+	// Nested are the failing tasks of nested pipelines(e.g. fallback, composite), the outer is the first
+	Nested []NestedTask
+	// Err is the cause of error
+	Err error
+}
+
+// NestedTask is the failing task of nested pipeline
+type NestedTask struct {
+	Index int
+	Task  string
+}
+
+func formatTask(index int, name string) string {
+	task := "task[" + strconv.Itoa(index) + "]"
+	if name != "" {
+		task += " " + name
+	}
+	return task
+}
+
+func (e *Error) Error() string {
+	arr := make([]string, 0, 3)
+	if e.Index >= 0 {
+		tasks := make([]string, 0, len(e.Nested)+1)
+		tasks = append(tasks, formatTask(e.Index, e.Task))
+		for _, item := range e.Nested {
+			tasks = append(tasks, formatTask(item.Index, item.Task))
+		}
+		arr = append(arr, strings.Join(tasks, " > "))
+	}
+	if e.Category != nil {
+		arr = append(arr, e.Category.Error())
+	}
+	arr = append(arr, e.Err.Error())
+	return strings.Join(arr, ": ")
+}
+
+// Unwrap returns the cause of error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if the target is the category of error
+func (e *Error) Is(target error) bool {
+	return e.Category != nil && e.Category == target
+}
+
+// wrapError wraps the error with category,
+// the error will be returned directly if it is categorized
+func wrapError(category, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) && e.Category != nil {
+		return err
+	}
+	return &Error{
+		Category: category,
+		Index:    -1,
+		Err:      err,
+	}
+}
+
+func newInvalidParamError(message string) error {
+	return wrapError(ErrInvalidParam, errors.New(message))
+}
+
+// withTask sets the task index and name of the error,
+// the task of inner pipeline is kept in the nested tasks
+func withTask(err error, index int, name string) error {
+	if e, ok := err.(*Error); ok {
+		result := *e
+		if e.Index >= 0 {
+			result.Nested = append([]NestedTask{
+				{
+					Index: e.Index,
+					Task:  e.Task,
+				},
+			}, e.Nested...)
+		}
+		result.Index = index
+		result.Task = name
+		return &result
+	}
+	return &Error{
+		Index: index,
+		Task:  name,
+		Err:   err,
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(wrapError(ErrNotFound, nil))
+
+	cause := errors.New("no such file")
+	err := wrapError(ErrNotFound, cause)
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.True(errors.Is(err, cause))
+	assert.False(errors.Is(err, ErrUpstream))
+	assert.Equal("not found: no such file", err.Error())
+
+	// 已分类的error不再重复分类
+	assert.Equal(err, wrapError(ErrUpstream, err))
+	wrapped := fmt.Errorf("read fail, %w", err)
+	assert.Equal(wrapped, wrapError(ErrUpstream, wrapped))
+}
+
+func TestWithTask(t *testing.T) {
+	assert := assert.New(t)
+
+	cause := errors.New("no such file")
+	err := withTask(wrapError(ErrNotFound, cause), 1, "fileFinder")
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.True(errors.Is(err, cause))
+	assert.Equal("task[1] fileFinder: not found: no such file", err.Error())
+	e := &Error{}
+	assert.True(errors.As(err, &e))
+	assert.Equal(1, e.Index)
+	assert.Equal("fileFinder", e.Task)
+
+	// 内层pipeline的任务记录在nested中
+	err = withTask(withTask(err, 2, "composite"), 3, "fallback")
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.Equal("task[3] fallback > task[2] composite > task[1] fileFinder: not found: no such file", err.Error())
+	assert.True(errors.As(err, &e))
+	assert.Equal(3, e.Index)
+	assert.Equal("fallback", e.Task)
+	assert.Equal([]NestedTask{
+		{
+			Index: 2,
+			Task:  "composite",
+		},
+		{
+			Index: 1,
+			Task:  "fileFinder",
+		},
+	}, e.Nested)
+
+	// 未分类的error
+	err = withTask(fmt.Errorf("wrapped, %w", wrapError(ErrUpstream, cause)), 3, "")
+	assert.True(errors.Is(err, ErrUpstream))
+	assert.Equal("task[3]: wrapped, upstream error: no such file", err.Error())
+	err = withTask(cause, 0, "custom")
+	assert.False(errors.Is(err, ErrUpstream))
+	assert.Equal("task[0] custom: no such file", err.Error())
+}
+
+func TestDoError(t *testing.T) {
+	assert := assert.New(t)
+
+	err := AddFileFinder("errorFileFinder", "/tmp")
+	assert.Nil(err)
+	jobs, err := Parse("errorFileFinder/not-exists.png|fitResize/100/100", "")
+	assert.Nil(err)
+	_, err = Do(context.Background(), nil, jobs...)
+	assert.True(errors.Is(err, ErrNotFound))
+	e := &Error{}
+	assert.True(errors.As(err, &e))
+	assert.Equal(0, e.Index)
+	assert.Equal("errorFileFinder", e.Task)
+
+	_, err = Parse("fitResize/100/100|fillResize", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	assert.Equal("task[1] fillResize: invalid param: fill resize width and height can not be nil", err.Error())
+
+	// 嵌套的任务出错时，序号为顶层pipeline中的任务
+	jobs, err = Parse("fitResize/100/100|fillResize/50/50|fallback(errorFileFinder/zzz.png)", "")
+	assert.Nil(err)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	_, err = Do(context.Background(), img, jobs...)
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.True(errors.As(err, &e))
+	assert.Equal(2, e.Index)
+	assert.Equal(TaskFallback, e.Task)
+	assert.Equal([]NestedTask{
+		{
+			Index: 0,
+			Task:  "errorFileFinder",
+		},
+	}, e.Nested)
+	assert.True(strings.HasPrefix(err.Error(), "task[2] fallback > task[0] errorFileFinder: not found: "))
+
+	_, err = Parse("fitResize/100/100|composite(errorFileFinder/a.png|fillResize)", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	assert.Equal("task[1] composite > task[1] fillResize: invalid param: fill resize width and height can not be nil", err.Error())
+
+	_, err = Parse("fitResize/100/100|notExistsFinder/a.png", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	assert.True(errors.Is(err, ErrFinderNotFound))
+
+	_, err = NewImageFromBytes([]byte("abc"))
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+}
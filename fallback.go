@@ -0,0 +1,75 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+)
+
+// NewPipelineJob creates a job, which runs the jobs as a pipeline
+func NewPipelineJob(jobs ...Job) Job {
+	return func(ctx context.Context, img *Image) (*Image, error) {
+		return Do(ctx, img, jobs...)
+	}
+}
+
+// NewFallbackJob creates a job, which runs the jobs in order until one of them succeeds.
+// Only the not found error(ErrNotFound) makes the next job run, other errors will be returned directly.
+func NewFallbackJob(jobs ...Job) Job {
+	return func(ctx context.Context, img *Image) (*Image, error) {
+		err := wrapError(ErrNotFound, errors.New("fallback jobs can not be nil"))
+		for _, fn := range jobs {
+			var result *Image
+			result, err = fn(ctx, img)
+			if err == nil || err == ErrAbortNext {
+				return result, err
+			}
+			if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+}
+
+// parseParenTask returns the content in parens of task, e.g. `fallback(a,b)` returns `a,b`
+func parseParenTask(name, task string) (string, error) {
+	start := len(name)
+	end := findCloseParen(task, start)
+	if end != len(task)-1 {
+		return "", errors.New(name + " task is invalid")
+	}
+	return task[start+1 : end], nil
+}
+
+// parseFallback parses the fallback task, the pipelines are separated by `,`,
+// e.g. `fallback(minioFinder/bucket/a.png,gridfsFinder/id|fitResize/100/0,fileFinder/missing.png)`
+func parseFallback(task, accept string) (Job, error) {
+	content, err := parseParenTask(TaskFallback, task)
+	if err != nil {
+		return nil, err
+	}
+	pipelines := splitTasks(content, ',')
+	jobs := make([]Job, 0, len(pipelines))
+	for _, pipeline := range pipelines {
+		pipelineJobs, err := Parse(pipeline, accept)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, NewPipelineJob(pipelineJobs...))
+	}
+	return NewFallbackJob(jobs...), nil
+}
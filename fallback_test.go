@@ -0,0 +1,94 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFallbackJob(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	notFound := func(_ context.Context, _ *Image) (*Image, error) {
+		return nil, wrapError(ErrNotFound, errors.New("image is not found"))
+	}
+	upstreamErr := wrapError(ErrUpstream, errors.New("service unavailable"))
+	upstream := func(_ context.Context, _ *Image) (*Image, error) {
+		return nil, upstreamErr
+	}
+	found := func(_ context.Context, _ *Image) (*Image, error) {
+		return img, nil
+	}
+
+	result, err := NewFallbackJob(notFound, found)(context.Background(), nil)
+	assert.Nil(err)
+	assert.Equal(img, result)
+
+	// 非not found的出错直接返回
+	_, err = NewFallbackJob(notFound, upstream, found)(context.Background(), nil)
+	assert.Equal(upstreamErr, err)
+
+	_, err = NewFallbackJob(notFound, notFound)(context.Background(), nil)
+	assert.True(errors.Is(err, ErrNotFound))
+
+	_, err = NewFallbackJob()(context.Background(), nil)
+	assert.True(errors.Is(err, ErrNotFound))
+}
+
+func TestParseFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	legacyPath, err := ioutil.TempDir("", "legacy")
+	assert.Nil(err)
+	defer os.RemoveAll(legacyPath)
+	placeholderPath, err := ioutil.TempDir("", "placeholder")
+	assert.Nil(err)
+	defer os.RemoveAll(placeholderPath)
+
+	err = ioutil.WriteFile(filepath.Join(placeholderPath, "missing.jpg"), newImageData(), 0600)
+	assert.Nil(err)
+	assert.Nil(AddFileFinder("legacyFinder", legacyPath))
+	assert.Nil(AddFileFinder("placeholderFinder", placeholderPath))
+
+	jobs, err := Parse("fallback(legacyFinder/a.jpg,placeholderFinder/missing.jpg|fitResize/100/0)|fillResize/50/50", "")
+	assert.Nil(err)
+	assert.Equal(2, len(jobs))
+	img, err := Do(context.Background(), nil, jobs...)
+	assert.Nil(err)
+	assert.Equal(50, img.Width())
+	assert.Equal(50, img.Height())
+
+	jobs, err = Parse("fallback(legacyFinder/a.jpg,placeholderFinder/b.jpg)", "")
+	assert.Nil(err)
+	_, err = Do(context.Background(), nil, jobs...)
+	assert.True(errors.Is(err, ErrNotFound))
+
+	_, err = Parse("fallback(legacyFinder/a.jpg", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("fallback(legacyFinder/a.jpg)/abc", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("fallback(legacyFinder/a.jpg,notFoundFinder/b.jpg)", "")
+	assert.True(errors.Is(err, ErrFinderNotFound))
+}
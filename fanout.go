@@ -0,0 +1,80 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DoBranches runs the prefix jobs once, then runs the job chains of branches concurrently,
+// the limit is the max number of branches running at the same time(0 means no limit).
+// It returns the images keyed by branch name, the first error will cancel the other branches,
+// and the error of ctx is returned if the branches are skipped as ctx is done.
+func DoBranches(ctx context.Context, img *Image, prefix []Job, branches map[string][]Job, limit int) (map[string]*Image, error) {
+	img, err := Do(ctx, img, prefix...)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > len(branches) {
+		limit = len(branches)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result := make(map[string]*Image, len(branches))
+	var firstErr error
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	limiter := make(chan struct{}, limit)
+	for name, jobs := range branches {
+		limiter <- struct{}{}
+		// 已出错或已取消则不再执行后续的分支
+		if err := ctx.Err(); err != nil {
+			<-limiter
+			mutex.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mutex.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(name string, jobs []Job) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			// 图片不会被job修改，因此各分支可共用
+			output, err := Do(ctx, img, jobs...)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("branch(%s) fail, %w", name, err)
+					cancel()
+				}
+				return
+			}
+			result[name] = output
+		}(name, jobs)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoBranches(t *testing.T) {
+	assert := assert.New(t)
+
+	var prefixCount int32
+	prefix := []Job{
+		func(_ context.Context, _ *Image) (*Image, error) {
+			atomic.AddInt32(&prefixCount, 1)
+			return NewImageFromBytes(newImageData())
+		},
+	}
+	branches := map[string][]Job{
+		"small": {
+			NewFitResizeImage(100, 0),
+		},
+		"medium": {
+			NewFitResizeImage(400, 0),
+		},
+		"square": {
+			NewFillResizeImage(200, 200),
+		},
+		"original": {},
+	}
+	result, err := DoBranches(context.Background(), nil, prefix, branches, 2)
+	assert.Nil(err)
+	assert.Equal(int32(1), prefixCount)
+	assert.Equal(4, len(result))
+	assert.Equal(100, result["small"].Width())
+	assert.Equal(400, result["medium"].Width())
+	assert.Equal(200, result["square"].Width())
+	assert.Equal(200, result["square"].Height())
+	assert.Equal(829, result["original"].Width())
+}
+
+func TestDoBranchesError(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	customErr := wrapError(ErrUpstream, errors.New("optimize fail"))
+	_, err = DoBranches(context.Background(), img, nil, map[string][]Job{
+		"webp": {
+			func(_ context.Context, _ *Image) (*Image, error) {
+				return nil, customErr
+			},
+		},
+		"small": {
+			NewFitResizeImage(100, 0),
+		},
+	}, 0)
+	assert.True(errors.Is(err, ErrUpstream))
+	assert.Equal("branch(webp) fail, task[0]: upstream error: optimize fail", err.Error())
+
+	_, err = DoBranches(context.Background(), nil, []Job{
+		func(_ context.Context, _ *Image) (*Image, error) {
+			return nil, customErr
+		},
+	}, map[string][]Job{
+		"small": {
+			NewFitResizeImage(100, 0),
+		},
+	}, 0)
+	assert.True(errors.Is(err, ErrUpstream))
+}
+
+func TestDoBranchesCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := DoBranches(ctx, img, nil, map[string][]Job{
+		"small": {
+			NewFitResizeImage(100, 0),
+		},
+	}, 1)
+	assert.Nil(result)
+	assert.Equal(context.Canceled, err)
+}
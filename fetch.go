@@ -15,29 +15,270 @@
 package imagepipeline
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// FetchImageFromURL fetch image from http url
-func FetchImageFromURL(ctx context.Context, url string) (*Image, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+const (
+	defaultFetchConnectTimeout = 5 * time.Second
+	defaultFetchReadTimeout    = 30 * time.Second
+	defaultFetchMaxBodySize    = 20 * 1024 * 1024
+	// 检测图片类型的数据长度
+	magicSize = 12
+)
+
+// FetcherOptions is the options of fetcher
+type FetcherOptions struct {
+	// Client is the http client, a client with the connect and read timeouts is created if it is nil
+	Client *http.Client
+	// ConnectTimeout is the timeout of connecting, default is 5s,
+	// it is ignored if the client is set
+	ConnectTimeout time.Duration
+	// ReadTimeout is the timeout of waiting the response header, and the timeout of reading body,
+	// default is 30s
+	ReadTimeout time.Duration
+	// MaxBodySize is the max size of response body, default is 20MB
+	MaxBodySize int64
+	// Policy is the url policy, DefaultURLPolicy is used if it is nil.
+	// The ip addresses are checked after DNS resolution, so the transport of client should be *http.Transport
+	// unless the private ip is allowed, and the proxy of transport is not used for the checked requests.
+	Policy *URLPolicy
+}
+
+// Fetcher fetches the image from http url
+type Fetcher struct {
+	// client is used for the urls checked by policy
+	client *http.Client
+	// trustedClient is used for the trusted urls, it does not share connections with client
+	trustedClient *http.Client
+	readTimeout   time.Duration
+	maxBodySize   int64
+	policy        *URLPolicy
+}
+
+var defaultFetcher atomic.Value
+
+func init() {
+	// 未指定client时不会出错
+	f, _ := NewFetcher(FetcherOptions{})
+	SetDefaultFetcher(f)
+}
+
+// SetDefaultFetcher sets the fetcher used by FetchImageFromURL
+func SetDefaultFetcher(f *Fetcher) {
+	defaultFetcher.Store(f)
+}
+
+func getDefaultFetcher() *Fetcher {
+	f, _ := defaultFetcher.Load().(*Fetcher)
+	return f
+}
+
+// NewFetcher returns a new fetcher, ErrURLPolicyNotEnforced is returned if the transport of client
+// is not *http.Transport(e.g. a wrapper) and the private ip is not allowed by policy,
+// because the ip addresses can not be checked after DNS resolution
+func NewFetcher(opts FetcherOptions) (*Fetcher, error) {
+	f := &Fetcher{
+		readTimeout: opts.ReadTimeout,
+		maxBodySize: opts.MaxBodySize,
+		policy:      opts.Policy,
+	}
+	if f.policy == nil {
+		f.policy = DefaultURLPolicy
+	}
+	if f.readTimeout <= 0 {
+		f.readTimeout = defaultFetchReadTimeout
+	}
+	if f.maxBodySize <= 0 {
+		f.maxBodySize = defaultFetchMaxBodySize
+	}
+	if opts.Client == nil {
+		connectTimeout := opts.ConnectTimeout
+		if connectTimeout <= 0 {
+			connectTimeout = defaultFetchConnectTimeout
+		}
+		dialer := &net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		trustedTransport := http.DefaultTransport.(*http.Transport).Clone()
+		trustedTransport.DialContext = dialer.DialContext
+		trustedTransport.TLSHandshakeTimeout = connectTimeout
+		trustedTransport.ResponseHeaderTimeout = f.readTimeout
+		f.trustedClient = &http.Client{
+			Transport: trustedTransport,
+		}
+		f.client = &http.Client{
+			Transport:     newPolicyTransport(trustedTransport),
+			CheckRedirect: checkRedirect,
+		}
+		return f, nil
+	}
+	f.trustedClient = opts.Client
+	// 复制client，添加url policy的检测
+	client := *opts.Client
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if t, ok := transport.(*http.Transport); ok {
+		client.Transport = newPolicyTransport(t)
+	} else if !f.policy.AllowPrivateIP {
+		// 无法在DNS解析后检测ip，不允许使用
+		return nil, ErrURLPolicyNotEnforced
+	}
+	customCheckRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		err := checkRedirect(req, via)
+		if err != nil || customCheckRedirect == nil {
+			return err
+		}
+		return customCheckRedirect(req, via)
+	}
+	f.client = &client
+	return f, nil
+}
+
+// newPolicyTransport returns a copy of transport which checks the ip addresses by policy,
+// the connections are not shared with the transport, and the proxy is not used,
+// otherwise the ip address of proxy is checked instead of the target.
+func newPolicyTransport(transport *http.Transport) *http.Transport {
+	t := transport.Clone()
+	t.Proxy = nil
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = newPolicyDialContext(dial)
+	return t
+}
+
+// isImageContentType returns true if the content type is image or binary,
+// the empty content type is also allowed
+func isImageContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/octet-stream" ||
+		strings.HasPrefix(mediaType, "image/")
+}
+
+// isImageData returns true if the data starts with the magic bytes of image
+func isImageData(data []byte) bool {
+	switch {
+	// jpeg
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return true
+	// png
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return true
+	// gif
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return true
+	// webp
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return true
+	// avif等iso媒体文件
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		return true
+	// bmp
+	case bytes.HasPrefix(data, []byte("BM")):
+		return true
+	}
+	return false
+}
+
+// Fetch fetches the image from url, the context is propagated to the request,
+// and the url(including redirects) is checked by the policy of fetcher
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*Image, error) {
+	return f.fetch(ctx, url, f.policy)
+}
+
+// fetch fetches the image from url, the url is not checked if the policy is nil
+func (f *Fetcher) fetch(ctx context.Context, url string, policy *URLPolicy) (*Image, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidParam, err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	client := f.trustedClient
+	if policy != nil {
+		err = policy.CheckURL(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(withURLPolicy(ctx, policy))
+		client = f.client
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		// 如果是policy的出错则直接返回
+		var e *Error
+		if errors.As(err, &e) {
+			return nil, e
+		}
+		return nil, wrapError(ErrUpstream, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fetch image fail, status:%d", resp.StatusCode)
+		category := ErrUpstream
+		if resp.StatusCode == http.StatusNotFound {
+			category = ErrNotFound
+		}
+		return nil, wrapError(category, fmt.Errorf("fetch image fail, status:%d", resp.StatusCode))
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !isImageContentType(contentType) {
+		return nil, wrapError(ErrUnsupportedFormat, errors.New("content type is not image: "+contentType))
+	}
+	if resp.ContentLength > f.maxBodySize {
+		return nil, wrapError(ErrTooLarge, fmt.Errorf("body is too large, size:%d, limit:%d", resp.ContentLength, f.maxBodySize))
+	}
+	// 读取数据超时则取消请求
+	timer := time.AfterFunc(f.readTimeout, cancel)
+	defer timer.Stop()
+
+	r := bufio.NewReader(io.LimitReader(resp.Body, f.maxBodySize+1))
+	magic, err := r.Peek(magicSize)
+	if err != nil && err != io.EOF {
+		return nil, wrapError(ErrUpstream, err)
+	}
+	if !isImageData(magic) {
+		return nil, wrapError(ErrUnsupportedFormat, errors.New("data is not image"))
 	}
-	buf, err := ioutil.ReadAll(resp.Body)
+	buf, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrUpstream, err)
+	}
+	if int64(len(buf)) > f.maxBodySize {
+		return nil, wrapError(ErrTooLarge, fmt.Errorf("body is too large, limit:%d", f.maxBodySize))
 	}
 	return NewImageFromBytes(buf)
 }
+
+// FetchImageFromURL fetch image from http url with the default fetcher,
+// it is used by the proxy and watermark tasks, so the url is checked by the policy of fetcher
+func FetchImageFromURL(ctx context.Context, url string) (*Image, error) {
+	return getDefaultFetcher().Fetch(ctx, url)
+}
+
+// fetchTrustedURL fetches image from the trusted url(e.g. the upstream of http finder),
+// the url is not checked by the policy
+func fetchTrustedURL(ctx context.Context, url string) (*Image, error) {
+	return getDefaultFetcher().fetch(ctx, url, nil)
+}
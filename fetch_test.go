@@ -16,7 +16,13 @@ package imagepipeline
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,3 +33,187 @@ func TestFetchImageFromURL(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal("png", img.format)
 }
+
+var allowPrivatePolicy = &URLPolicy{
+	AllowPrivateIP: true,
+}
+
+func TestFetchImageFromURLError(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/not-found" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer s.Close()
+
+	// 测试服务为本机地址
+	SetDefaultFetcher(mustNewFetcher(FetcherOptions{
+		Policy: allowPrivatePolicy,
+	}))
+	defer SetDefaultFetcher(mustNewFetcher(FetcherOptions{}))
+
+	_, err := FetchImageFromURL(context.Background(), s.URL+"/not-found")
+	assert.True(errors.Is(err, ErrNotFound))
+
+	_, err = FetchImageFromURL(context.Background(), s.URL+"/error")
+	assert.True(errors.Is(err, ErrUpstream))
+	assert.Equal("upstream error: fetch image fail, status:502", err.Error())
+}
+
+func TestFetcher(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "/text":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("hello world, it is not image"))
+		case "/slow-header":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write(newImageData())
+		case "/slow-body":
+			data := newImageData()
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write(data[:1024])
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write(data[1024:])
+		default:
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = w.Write(newImageData())
+		}
+	}))
+	defer s.Close()
+
+	f := mustNewFetcher(FetcherOptions{
+		ReadTimeout: 100 * time.Millisecond,
+		Policy:      allowPrivatePolicy,
+	})
+	img, err := f.Fetch(context.Background(), s.URL+"/image")
+	assert.Nil(err)
+	assert.Equal(ImageTypeJPEG, img.format)
+
+	_, err = f.Fetch(context.Background(), s.URL+"/html")
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+	assert.Equal("unsupported format: content type is not image: text/html", err.Error())
+
+	_, err = f.Fetch(context.Background(), s.URL+"/text")
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+	assert.Equal("unsupported format: data is not image", err.Error())
+
+	_, err = f.Fetch(context.Background(), s.URL+"/slow-header")
+	assert.True(errors.Is(err, ErrUpstream))
+	_, err = f.Fetch(context.Background(), s.URL+"/slow-body")
+	assert.True(errors.Is(err, ErrUpstream))
+
+	// 请求使用传入的context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.Fetch(ctx, s.URL+"/image")
+	assert.True(errors.Is(err, context.Canceled))
+
+	_, err = mustNewFetcher(FetcherOptions{
+		MaxBodySize: 1024,
+		Policy:      allowPrivatePolicy,
+	}).Fetch(context.Background(), s.URL+"/image")
+	assert.True(errors.Is(err, ErrTooLarge))
+
+	// 自定义的client
+	var count int32
+	client := &http.Client{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&count, 1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+	}
+	SetDefaultFetcher(mustNewFetcher(FetcherOptions{
+		Client: client,
+		Policy: allowPrivatePolicy,
+	}))
+	defer SetDefaultFetcher(mustNewFetcher(FetcherOptions{}))
+	_, err = FetchImageFromURL(context.Background(), s.URL+"/image")
+	assert.Nil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&count))
+}
+
+func TestFetcherTransport(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(newImageData())
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	assert.Nil(err)
+	localURL := "http://localhost:" + u.Port() + "/image"
+
+	f := mustNewFetcher(FetcherOptions{})
+	// policy检测的请求不使用代理，且与信任的请求不共用连接
+	transport := f.client.Transport.(*http.Transport)
+	assert.Nil(transport.Proxy)
+	assert.NotEqual(transport, f.trustedClient.Transport)
+
+	_, err = f.fetch(context.Background(), localURL, nil)
+	assert.Nil(err)
+	_, err = f.Fetch(context.Background(), localURL)
+	assert.True(errors.Is(err, ErrForbidden))
+
+	// 自定义的client
+	custom := mustNewFetcher(FetcherOptions{
+		Client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+		},
+	})
+	assert.Nil(custom.client.Transport.(*http.Transport).Proxy)
+	assert.NotNil(custom.trustedClient.Transport.(*http.Transport).Proxy)
+	_, err = custom.fetch(context.Background(), localURL, nil)
+	assert.Nil(err)
+	_, err = custom.Fetch(context.Background(), localURL)
+	assert.True(errors.Is(err, ErrForbidden))
+
+	// 无法检测ip的transport
+	_, err = NewFetcher(FetcherOptions{
+		Client: &http.Client{
+			Transport: roundTripperFunc(http.DefaultTransport.RoundTrip),
+		},
+	})
+	assert.Equal(ErrURLPolicyNotEnforced, err)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func mustNewFetcher(opts FetcherOptions) *Fetcher {
+	f, err := NewFetcher(opts)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func TestIsImageData(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isImageData(newImageData()[:magicSize]))
+	assert.True(isImageData([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.True(isImageData([]byte("\x00\x00\x00\x1cftypavif")))
+	assert.False(isImageData([]byte("<svg></svg>")))
+	assert.False(isImageData(nil))
+
+	assert.True(isImageContentType(""))
+	assert.True(isImageContentType("image/png; charset=binary"))
+	assert.False(isImageContentType("text/plain"))
+}
@@ -20,7 +20,9 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -53,17 +55,26 @@ type httpFinder struct {
 
 func (hf *httpFinder) Find(ctx context.Context, params ...string) (*Image, error) {
 	if len(params) < 1 {
-		return nil, errors.New("http params should be one parameter")
+		return nil, newInvalidParamError("http params should be one parameter")
 	}
 	requestURI, err := url.QueryUnescape(params[0])
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidParam, err)
 	}
 	u := hf.uh.PolicyRoundRobin()
 	if u == nil {
-		return nil, errors.New("get http upstream fail")
+		return nil, wrapError(ErrUpstream, errors.New("get http upstream fail"))
 	}
-	return FetchImageFromURL(ctx, u.URL.String()+requestURI)
+	target, err := url.Parse(u.URL.String() + requestURI)
+	if err != nil {
+		return nil, wrapError(ErrInvalidParam, err)
+	}
+	// 避免通过参数(如`@169.254.169.254/`)修改请求的地址
+	if target.Scheme != u.URL.Scheme || target.Host != u.URL.Host || target.User != nil {
+		return nil, newForbiddenError(ErrURLHostNotAllowed, target.Host)
+	}
+	// upstream为配置的地址，可以是内网地址
+	return fetchTrustedURL(ctx, target.String())
 }
 func (hf *httpFinder) Close(ctx context.Context) error {
 	hf.uh.StopHealthCheck()
@@ -102,16 +113,19 @@ type fileFinder struct {
 
 func (ff *fileFinder) Find(ctx context.Context, params ...string) (*Image, error) {
 	if len(params) < 1 {
-		return nil, errors.New("file params should be one parameter")
+		return nil, newInvalidParamError("file params should be one parameter")
 	}
 	file := path.Join(ff.basePath, params[0])
 	// 避免文件超出目录
 	if !strings.HasPrefix(file, ff.basePath) {
-		return nil, errors.New("file name is invald")
+		return nil, newInvalidParamError("file name is invald")
 	}
 	buf, err := ioutil.ReadFile(file)
 	if err != nil {
-		return nil, err
+		if os.IsNotExist(err) {
+			return nil, wrapError(ErrNotFound, err)
+		}
+		return nil, wrapError(ErrUpstream, err)
 	}
 	return NewImageFromBytes(buf)
 }
@@ -134,18 +148,29 @@ type minioFinder struct {
 
 func (mf *minioFinder) Find(ctx context.Context, params ...string) (*Image, error) {
 	if len(params) < 2 {
-		return nil, errors.New("minio param should be two parameters")
+		return nil, newInvalidParamError("minio param should be two parameters")
 	}
 	obj, err := mf.client.GetObject(ctx, params[0], params[1], minio.GetObjectOptions{})
 	if err != nil {
-		return nil, err
+		return nil, convertMinioError(err)
 	}
+	// minio的object是在读取时才请求，因此读取时的出错也需要转换
 	buf, err := io.ReadAll(obj)
 	if err != nil {
-		return nil, err
+		return nil, convertMinioError(err)
 	}
 	return NewImageFromBytes(buf)
 }
+func convertMinioError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return wrapError(ErrNotFound, err)
+	case "InvalidBucketName", "XMinioInvalidObjectName":
+		return wrapError(ErrInvalidParam, err)
+	}
+	return wrapError(ErrUpstream, err)
+}
+
 func (mf *minioFinder) Close(_ context.Context) error {
 	return nil
 }
@@ -178,7 +203,7 @@ type gridFSFinder struct {
 
 func (gf *gridFSFinder) Find(ctx context.Context, params ...string) (*Image, error) {
 	if len(params) == 0 {
-		return nil, errors.New("gridfs param should be one parameter")
+		return nil, newInvalidParamError("gridfs param should be one parameter")
 	}
 	db := gf.client.Database(gf.database)
 	collection := options.DefaultName
@@ -187,16 +212,19 @@ func (gf *gridFSFinder) Find(ctx context.Context, params ...string) (*Image, err
 	}
 	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(collection))
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrUpstream, err)
 	}
 	buffer := bytes.Buffer{}
 	id, err := primitive.ObjectIDFromHex(params[0])
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidParam, err)
 	}
 	_, err = bucket.DownloadToStream(id, &buffer)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, wrapError(ErrNotFound, err)
+		}
+		return nil, wrapError(ErrUpstream, err)
 	}
 	return NewImageFromBytes(buffer.Bytes())
 }
@@ -233,23 +261,32 @@ type aliyunOSSFinder struct {
 
 func (af *aliyunOSSFinder) Find(_ context.Context, params ...string) (*Image, error) {
 	if len(params) < 2 {
-		return nil, errors.New("oss param should be two parameters")
+		return nil, newInvalidParamError("oss param should be two parameters")
 	}
 	bucket, err := af.client.Bucket(params[0])
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrInvalidParam, err)
 	}
 	r, err := bucket.GetObject(params[1])
 	if err != nil {
-		return nil, err
+		return nil, convertOSSError(err)
 	}
+	defer r.Close()
 	buf, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrUpstream, err)
 	}
 	return NewImageFromBytes(buf)
 }
 
+func convertOSSError(err error) error {
+	var se oss.ServiceError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return wrapError(ErrNotFound, err)
+	}
+	return wrapError(ErrUpstream, err)
+}
+
 func (af *aliyunOSSFinder) Close(_ context.Context) error {
 	return nil
 }
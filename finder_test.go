@@ -17,10 +17,12 @@ package imagepipeline
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
 	"time"
@@ -48,7 +50,7 @@ func TestAddHTTPFinder(t *testing.T) {
 
 	mux.Handle("/image", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		buf := newImageData()
-		w.Header().Add("Content-Type", "mage/png")
+		w.Header().Add("Content-Type", "image/png")
 		_, _ = w.Write(buf)
 	}))
 
@@ -72,6 +74,16 @@ func TestAddHTTPFinder(t *testing.T) {
 	assert.Equal(829, img.Width())
 	assert.Equal(846, img.Height())
 
+	// 参数不可修改请求的地址
+	for _, requestURI := range []string{
+		"@169.254.169.254/latest/meta-data",
+		url.QueryEscape("@169.254.169.254/latest/meta-data"),
+	} {
+		_, err = finder.Find(context.Background(), requestURI)
+		assert.True(errors.Is(err, ErrForbidden), requestURI)
+		assert.True(errors.Is(err, ErrURLHostNotAllowed), requestURI)
+	}
+
 	err = finder.Close(context.Background())
 	assert.Nil(err)
 }
@@ -0,0 +1,150 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+var ErrHistoryNotEnough = errors.New("history of image is not enough")
+
+var defaultHistoryDepth int32
+
+var maxHistoryDepth int32 = 10
+
+// SetDefaultHistoryDepth sets the default history depth of new image, the default is 0,
+// which means the previous images will not be kept
+func SetDefaultHistoryDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	atomic.StoreInt32(&defaultHistoryDepth, int32(depth))
+}
+
+func getDefaultHistoryDepth() int {
+	return int(atomic.LoadInt32(&defaultHistoryDepth))
+}
+
+// SetMaxHistoryDepth sets the max history depth of history task, the default is 10
+func SetMaxHistoryDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	atomic.StoreInt32(&maxHistoryDepth, int32(depth))
+}
+
+func getMaxHistoryDepth() int {
+	return int(atomic.LoadInt32(&maxHistoryDepth))
+}
+
+// derive returns a copy of image, and the image is added to the history of copy
+func (i *Image) derive() *Image {
+	img := *i
+	img.history = nil
+	if i.historyDepth <= 0 {
+		return &img
+	}
+	previous := *i
+	previous.history = nil
+	history := i.history
+	if len(history) >= i.historyDepth {
+		history = history[len(history)-i.historyDepth+1:]
+	}
+	img.history = make([]*Image, 0, len(history)+1)
+	img.history = append(img.history, history...)
+	img.history = append(img.history, &previous)
+	return &img
+}
+
+// History returns the previous images, the oldest is the first
+func (i *Image) History() []*Image {
+	return i.history
+}
+
+// WithHistoryDepth returns a new image with the history depth,
+// the history will be truncated if it is longer than depth, and the negative depth is treated as 0
+func (i *Image) WithHistoryDepth(depth int) *Image {
+	if depth < 0 {
+		depth = 0
+	}
+	img := *i
+	img.historyDepth = depth
+	if len(img.history) > depth {
+		img.history = img.history[len(img.history)-depth:]
+	}
+	if depth == 0 {
+		img.history = nil
+	}
+	return &img
+}
+
+// Restore returns the image of n steps before, and the history before it is kept
+func (i *Image) Restore(n int) (*Image, error) {
+	if n <= 0 || n > len(i.history) {
+		return nil, wrapError(ErrInvalidParam, ErrHistoryNotEnough)
+	}
+	index := len(i.history) - n
+	img := *i.history[index]
+	img.history = i.history[:index:index]
+	img.historyDepth = i.historyDepth
+	return &img, nil
+}
+
+// NewRestoreImage creates a job, which restores the image of n steps before
+func NewRestoreImage(n int) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		return img.Restore(n)
+	}
+}
+
+// NewHistoryDepthImage creates a job, which sets the history depth of image
+func NewHistoryDepthImage(depth int) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		return img.WithHistoryDepth(depth), nil
+	}
+}
+
+func parseUndo(_ []string, _ string) (Job, error) {
+	return NewRestoreImage(1), nil
+}
+
+func parseRestore(params []string, _ string) (Job, error) {
+	if len(params) == 0 {
+		return nil, errors.New("restore step can not be nil")
+	}
+	n, err := strconv.Atoi(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return NewRestoreImage(n), nil
+}
+
+func parseHistory(params []string, _ string) (Job, error) {
+	if len(params) == 0 {
+		return nil, errors.New("history depth can not be nil")
+	}
+	depth, err := strconv.Atoi(params[0])
+	if err != nil {
+		return nil, err
+	}
+	if depth < 0 || depth > getMaxHistoryDepth() {
+		return nil, newInvalidParamError(fmt.Sprintf("history depth should be between 0 and %d", getMaxHistoryDepth()))
+	}
+	return NewHistoryDepthImage(depth), nil
+}
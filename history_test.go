@@ -0,0 +1,128 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	// 默认不保存历史
+	assert.Nil(img.WithGrid(image.NewNRGBA(image.Rect(0, 0, 1, 1))).Previous())
+
+	img = img.WithHistoryDepth(2)
+	for i := 1; i <= 4; i++ {
+		img = img.WithGrid(image.NewNRGBA(image.Rect(0, 0, i, i)))
+	}
+	history := img.History()
+	assert.Equal(2, len(history))
+	assert.Equal(2, history[0].Width())
+	assert.Equal(3, history[1].Width())
+	assert.Equal(3, img.Previous().Width())
+	// 历史中的图片不再保存其历史
+	for _, item := range history {
+		assert.Nil(item.History())
+	}
+
+	img = img.WithHistoryDepth(1)
+	assert.Equal(1, len(img.History()))
+	img = img.WithHistoryDepth(0)
+	assert.Nil(img.History())
+	// 负数视为0
+	img = img.WithHistoryDepth(-1)
+	assert.Nil(img.WithGrid(image.NewNRGBA(image.Rect(0, 0, 1, 1))).History())
+}
+
+func TestImageRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img = img.WithHistoryDepth(3)
+	for i := 1; i <= 3; i++ {
+		img = img.WithGrid(image.NewNRGBA(image.Rect(0, 0, i, i)))
+	}
+
+	result, err := img.Restore(1)
+	assert.Nil(err)
+	assert.Equal(2, result.Width())
+	assert.Equal(2, len(result.History()))
+
+	result, err = img.Restore(3)
+	assert.Nil(err)
+	assert.Equal(829, result.Width())
+	assert.Equal(newImageData(), result.optimizedData)
+	assert.Equal(0, len(result.History()))
+	// 恢复后的图片继续保存历史
+	result = result.WithGrid(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	assert.Equal(829, result.Previous().Width())
+	// 原图片的历史不受影响
+	assert.Equal(3, len(img.History()))
+	assert.Equal(1, img.History()[1].Width())
+
+	_, err = img.Restore(4)
+	assert.True(errors.Is(err, ErrHistoryNotEnough))
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
+
+func TestParseHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("history/2|fitResize/400/0|fillResize/100/100|undo", "")
+	assert.Nil(err)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = Do(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(400, img.Width())
+
+	jobs, err = Parse("history/2|fitResize/400/0|fillResize/100/100|restore/2", "")
+	assert.Nil(err)
+	img, err = NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = Do(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+
+	jobs, err = Parse("fitResize/400/0|undo", "")
+	assert.Nil(err)
+	img, err = NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	_, err = Do(context.Background(), img, jobs...)
+	assert.True(errors.Is(err, ErrHistoryNotEnough))
+
+	_, err = Parse("restore/abc", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("history", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("history/-1", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("history/11", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+
+	SetMaxHistoryDepth(20)
+	defer SetMaxHistoryDepth(10)
+	_, err = Parse("history/11", "")
+	assert.Nil(err)
+}
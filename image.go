@@ -22,9 +22,17 @@ import (
 	"github.com/disintegration/imaging"
 )
 
+// Image is the image of pipeline, it should be treated as immutable:
+// the jobs return a new image instead of modifying the image they are given,
+// and the new image shares the grid and data with the previous one if they are not changed.
+// So an image is safe to be used concurrently by several jobs(e.g. the branches of DoBranches),
+// unless the deprecated Set is called.
 type Image struct {
-	// previous is the previous before handle
-	previous *Image
+	// history is the images before handle, the oldest is the first,
+	// the images in history do not keep their own history
+	history []*Image
+	// historyDepth is the max length of history
+	historyDepth int
 	// originalSize is the original raw data size of image
 	originalSize int
 	// grid is the grid of color.Color values
@@ -33,6 +41,8 @@ type Image struct {
 	optimizedData []byte
 	// format is the format type of image
 	format string
+	// varyAccept is true if the format is negotiated by accept
+	varyAccept bool
 }
 
 // Job is the image pipeline job
@@ -42,28 +52,39 @@ type Job func(context.Context, *Image) (*Image, error)
 func NewImageFromBytes(data []byte) (*Image, error) {
 	img, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrUnsupportedFormat, err)
 	}
 	return &Image{
 		optimizedData: data,
 		originalSize:  len(data),
 		format:        format,
 		grid:          img,
+		historyDepth:  getDefaultHistoryDepth(),
 	}, nil
 }
 
-// Previous returns the previous image
+// Previous returns the previous image, it is nil if the history depth is 0
 func (i *Image) Previous() *Image {
-	return i.previous
+	if len(i.history) == 0 {
+		return nil
+	}
+	return i.history[len(i.history)-1]
 }
 
-// Set sets the image grid
+// Set sets the image grid, it modifies the image in place.
+//
+// Deprecated: use WithGrid instead, which returns a new image.
 func (i *Image) Set(grid image.Image) {
-	previous := *i
-	i.previous = &previous
+	*i = *i.WithGrid(grid)
+}
+
+// WithGrid returns a new image with the grid, the image is not modified
+func (i *Image) WithGrid(grid image.Image) *Image {
+	img := i.derive()
 	// the image is changed, reset the optimized data
-	i.optimizedData = nil
-	i.grid = grid
+	img.optimizedData = nil
+	img.grid = grid
+	return img
 }
 
 // Width returns the width of image
@@ -76,18 +97,42 @@ func (i *Image) Height() int {
 	return i.grid.Bounds().Dy()
 }
 
-func (i *Image) setOptimized(data []byte, format string) {
-	i.optimizedData = data
-	i.format = format
+// Size returns the size of encoded data,
+// the original size will be returned if the image has been changed
+func (i *Image) Size() int {
+	if len(i.optimizedData) != 0 {
+		return len(i.optimizedData)
+	}
+	return i.originalSize
+}
+
+// hasAlpha returns true if the grid has transparent pixel
+func hasAlpha(grid image.Image) bool {
+	if o, ok := grid.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return false
+}
+
+// withOptimized returns a new image with the optimized data, the image is not modified
+func (i *Image) withOptimized(data []byte, format string) *Image {
+	img := i.derive()
+	img.optimizedData = data
+	img.format = format
+	return img
 }
 
 func (i *Image) encode(format string) ([]byte, error) {
 	buffer := bytes.Buffer{}
 	f := imaging.JPEG
+	grid := i.grid
 	if format == ImageTypePNG {
 		f = imaging.PNG
+	} else if hasAlpha(grid) {
+		// jpeg不支持透明，以白色为背景，避免透明像素变为黑色
+		grid = flatten(grid, colorWhite)
 	}
-	err := imaging.Encode(&buffer, i.grid, f)
+	err := imaging.Encode(&buffer, grid, f)
 	if err != nil {
 		return nil, err
 	}
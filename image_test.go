@@ -15,6 +15,9 @@
 package imagepipeline
 
 import (
+	"context"
+	"image"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,8 +38,68 @@ func TestImageSet(t *testing.T) {
 	img, err := NewImageFromBytes(newImageData())
 	assert.Nil(err)
 
-	img.Set(img.grid)
-	assert.NotEqual(img.Previous(), img)
+	// 默认不保存历史
+	img.Set(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	assert.Nil(img.Previous())
+	assert.Equal(10, img.Width())
+
+	img, err = NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img = img.WithHistoryDepth(1)
+	img.Set(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	assert.Equal(10, img.Width())
+	previous := img.Previous()
+	assert.NotNil(previous)
+	assert.Equal(829, previous.Width())
+	assert.Equal(newImageData(), previous.optimizedData)
+}
+
+func TestImageWithGrid(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img = img.WithHistoryDepth(1)
+
+	grid := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	result := img.WithGrid(grid)
+	assert.Equal(img, result.Previous())
+	assert.Equal(10, result.Width())
+	assert.Empty(result.optimizedData)
+	// 原图片不受影响
+	assert.Equal(829, img.Width())
+	assert.Equal(newImageData(), img.optimizedData)
+}
+
+func TestImageConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	watermark := image.NewNRGBA(image.Rect(0, 0, 50, 20))
+	grid := img.grid
+
+	jobs := []Job{
+		NewFitResizeImage(100, 0),
+		NewFillResizeImage(200, 200),
+		NewWatermark(watermark, PositionBottomRight, 30),
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		for _, fn := range jobs {
+			wg.Add(1)
+			go func(fn Job) {
+				defer wg.Done()
+				_, err := fn(context.Background(), img)
+				assert.Nil(err)
+			}(fn)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(grid, img.grid)
+	assert.Equal(newImageData(), img.optimizedData)
+	assert.Nil(img.Previous())
 }
 
 func TestImageSetOptimized(t *testing.T) {
@@ -45,10 +108,13 @@ func TestImageSetOptimized(t *testing.T) {
 	img, err := NewImageFromBytes(newImageData())
 	assert.Nil(err)
 
-	img.setOptimized([]byte("abc"), "jpeg")
+	img = img.WithHistoryDepth(1)
+	result := img.withOptimized([]byte("abc"), "jpeg")
 
-	assert.Equal([]byte("abc"), img.optimizedData)
-	assert.Equal("jpeg", img.format)
+	assert.Equal([]byte("abc"), result.optimizedData)
+	assert.Equal("jpeg", result.format)
+	assert.Equal(img, result.Previous())
+	assert.Equal(newImageData(), img.optimizedData)
 }
 
 func TestImageEncode(t *testing.T) {
@@ -0,0 +1,143 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// coverage returns the anti-aliased coverage of pixel,
+// which is the distance from pixel center to the circle edge
+func coverage(x, y int, cx, cy, radius float64) float64 {
+	dx := float64(x) + 0.5 - cx
+	dy := float64(y) + 0.5 - cy
+	d := radius - math.Sqrt(dx*dx+dy*dy) + 0.5
+	if d <= 0 {
+		return 0
+	}
+	if d >= 1 {
+		return 1
+	}
+	return d
+}
+
+// roundMask applies the rounded corner alpha mask to the grid
+func roundMask(grid image.Image, radius float64) *image.NRGBA {
+	dst := imaging.Clone(grid)
+	w := dst.Bounds().Dx()
+	h := dst.Bounds().Dy()
+	maxRadius := math.Min(float64(w), float64(h)) / 2
+	if radius > maxRadius {
+		radius = maxRadius
+	}
+	if radius <= 0 {
+		return dst
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// 圆角所在的圆心
+			cx := float64(x) + 0.5
+			cy := float64(y) + 0.5
+			inCorner := false
+			if cx < radius {
+				cx = radius
+				inCorner = true
+			} else if cx > float64(w)-radius {
+				cx = float64(w) - radius
+				inCorner = true
+			}
+			if cy < radius {
+				cy = radius
+			} else if cy > float64(h)-radius {
+				cy = float64(h) - radius
+			} else {
+				inCorner = false
+			}
+			if !inCorner {
+				continue
+			}
+			c := coverage(x, y, cx, cy, radius)
+			if c >= 1 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+3] = uint8(float64(dst.Pix[i+3])*c + 0.5)
+		}
+	}
+	return dst
+}
+
+// alphaFormat returns the format which can carry alpha
+func alphaFormat(format string) string {
+	switch format {
+	case ImageTypePNG, ImageTypeWEBP, ImageTypeAVIF:
+		return format
+	}
+	return ImageTypePNG
+}
+
+// withAlphaGrid returns a new image with the grid,
+// the format will be changed to png if it can not carry alpha
+func (i *Image) withAlphaGrid(grid image.Image) *Image {
+	img := i.WithGrid(grid)
+	if hasAlpha(grid) {
+		img.format = alphaFormat(img.format)
+	}
+	return img
+}
+
+// NewRoundImage creates a job, which makes the corners of image rounded
+func NewRoundImage(radius int) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if radius <= 0 {
+			return img, nil
+		}
+		return img.withAlphaGrid(roundMask(img.grid, float64(radius))), nil
+	}
+}
+
+// NewCircleImage creates a job, which crops the center square of image,
+// and makes it circular
+func NewCircleImage() Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		size := img.Width()
+		if img.Height() < size {
+			size = img.Height()
+		}
+		grid := imaging.CropCenter(img.grid, size, size)
+		return img.withAlphaGrid(roundMask(grid, float64(size)/2)), nil
+	}
+}
+
+func parseRound(params []string, _ string) (Job, error) {
+	if len(params) == 0 {
+		return nil, errors.New("round radius can not be nil")
+	}
+	radius, err := strconv.Atoi(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return NewRoundImage(radius), nil
+}
+
+func parseCircle(_ []string, _ string) (Job, error) {
+	return NewCircleImage(), nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundMask(t *testing.T) {
+	assert := assert.New(t)
+
+	grid := roundMask(imaging.New(40, 30, colorBlack), 10)
+	// 四个角为透明
+	assert.Equal(uint8(0), grid.NRGBAAt(0, 0).A)
+	assert.Equal(uint8(0), grid.NRGBAAt(39, 0).A)
+	assert.Equal(uint8(0), grid.NRGBAAt(0, 29).A)
+	assert.Equal(uint8(0), grid.NRGBAAt(39, 29).A)
+	// 边缘中间与中心不透明
+	assert.Equal(uint8(255), grid.NRGBAAt(20, 0).A)
+	assert.Equal(uint8(255), grid.NRGBAAt(0, 15).A)
+	assert.Equal(uint8(255), grid.NRGBAAt(20, 15).A)
+	// 抗锯齿的边缘为半透明
+	partial := 0
+	for x := 0; x < 10; x++ {
+		a := grid.NRGBAAt(x, 2).A
+		if a != 0 && a != 255 {
+			partial++
+		}
+	}
+	assert.True(partial > 0)
+
+	// 圆角大于宽高的一半时以一半为准
+	grid = roundMask(imaging.New(20, 20, colorBlack), 100)
+	assert.Equal(uint8(0), grid.NRGBAAt(0, 0).A)
+	assert.Equal(uint8(255), grid.NRGBAAt(10, 10).A)
+}
+
+func TestNewRoundImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	result, err := NewRoundImage(50)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(829, result.Width())
+	assert.True(hasAlpha(result.grid))
+	// jpeg不支持透明，转换为png
+	assert.Equal(ImageTypePNG, result.format)
+	assert.Equal(ImageTypeJPEG, img.format)
+
+	webpImg := newColorImage(10, 10, colorBlack)
+	webpImg.format = ImageTypeWEBP
+	result, err = NewRoundImage(5)(context.Background(), webpImg)
+	assert.Nil(err)
+	assert.Equal(ImageTypeWEBP, result.format)
+
+	result, err = NewRoundImage(0)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(img, result)
+}
+
+func TestNewCircleImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	result, err := NewCircleImage()(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(829, result.Width())
+	assert.Equal(829, result.Height())
+	assert.Equal(ImageTypePNG, result.format)
+	grid := imaging.Clone(result.grid)
+	assert.Equal(uint8(0), grid.NRGBAAt(0, 0).A)
+	assert.Equal(uint8(0), grid.NRGBAAt(100, 100).A)
+	assert.Equal(uint8(255), grid.NRGBAAt(414, 414).A)
+	assert.Equal(uint8(255), grid.NRGBAAt(414, 1).A)
+}
+
+func TestParseRound(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("fillResize/100/100|round/10|circle", "")
+	assert.Nil(err)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = Do(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(100, img.Width())
+	assert.Equal(ImageTypePNG, img.format)
+
+	_, err = Parse("round", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("round/abc", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
@@ -0,0 +1,268 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// MontageOptions is the options of montage
+type MontageOptions struct {
+	// Columns is the count of columns, default is 4
+	Columns int
+	// CellWidth and CellHeight are the size of cell, the image is fitted into the cell
+	CellWidth  int
+	CellHeight int
+	// Spacing is the spacing between cells
+	Spacing int
+	// Background is the background color, default is white
+	Background color.Color
+	// Labels are drawn under the cells, it is optional
+	Labels []string
+	// Concurrency is the max number of sources running at the same time, default is 4
+	Concurrency int
+}
+
+const defaultMontageConcurrency = 4
+
+var labelFace = basicfont.Face7x13
+
+const labelPadding = 4
+
+// labelColor returns the color of label which is readable on the background
+func labelColor(background color.Color) color.Color {
+	c := color.NRGBAModel.Convert(background).(color.NRGBA)
+	if c.A < 128 {
+		return colorBlack
+	}
+	luminance := 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+	if luminance < 128 {
+		return colorWhite
+	}
+	return colorBlack
+}
+
+// drawLabel draws the label centered in the rect, the label will be truncated if it is too long
+func drawLabel(dst draw.Image, label string, r image.Rectangle, c color.Color) {
+	maxChars := r.Dx() / labelFace.Advance
+	if maxChars <= 0 {
+		return
+	}
+	if len(label) > maxChars {
+		label = label[:maxChars]
+	}
+	width := len(label) * labelFace.Advance
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: labelFace,
+		Dot:  fixed.P(r.Min.X+(r.Dx()-width)/2, r.Min.Y+labelFace.Ascent),
+	}
+	d.DrawString(label)
+}
+
+// getMontageImage gets the image from source and fits it into the cell
+func getMontageImage(ctx context.Context, source, fitResize Job) (*Image, error) {
+	img, err := source(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if img == nil {
+		return nil, newInvalidParamError("montage source returns nil image")
+	}
+	return fitResize(ctx, img)
+}
+
+// getMontageImages gets the images of sources concurrently, the first error will cancel the other sources
+func getMontageImages(ctx context.Context, sources []Job, fitResize Job, concurrency int) ([]*Image, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	images := make([]*Image, len(sources))
+	var firstErr error
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	limiter := make(chan struct{}, concurrency)
+	for index, source := range sources {
+		limiter <- struct{}{}
+		// 已出错或已取消则不再获取后续的图片
+		if err := ctx.Err(); err != nil {
+			<-limiter
+			mutex.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mutex.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(index int, source Job) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			img, err := getMontageImage(ctx, source, fitResize)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			images[index] = img
+		}(index, source)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return images, nil
+}
+
+// NewMontageImage creates a job, which gets the images from sources concurrently,
+// fits each into a cell and arranges them in a grid
+func NewMontageImage(sources []Job, opts MontageOptions) Job {
+	return func(ctx context.Context, _ *Image) (*Image, error) {
+		if len(sources) == 0 {
+			return nil, newInvalidParamError("montage sources can not be nil")
+		}
+		if opts.CellWidth <= 0 || opts.CellHeight <= 0 {
+			return nil, newInvalidParamError("montage cell width and height should be greater than 0")
+		}
+		columns := opts.Columns
+		if columns <= 0 {
+			columns = 4
+		}
+		if columns > len(sources) {
+			columns = len(sources)
+		}
+		var background color.Color = colorWhite
+		if opts.Background != nil {
+			background = opts.Background
+		}
+		concurrency := opts.Concurrency
+		if concurrency <= 0 {
+			concurrency = defaultMontageConcurrency
+		}
+
+		// 先校验画布尺寸，避免获取图片后才出错
+		if err := checkCanvasSize(opts.CellWidth, opts.CellHeight, opts.Spacing); err != nil {
+			return nil, err
+		}
+		labelHeight := 0
+		if len(opts.Labels) != 0 {
+			labelHeight = labelFace.Height + labelPadding
+		}
+		rows := (len(sources) + columns - 1) / columns
+		rowHeight := opts.CellHeight + labelHeight
+		width := columns*opts.CellWidth + (columns+1)*opts.Spacing
+		height := rows*rowHeight + (rows+1)*opts.Spacing
+		if err := checkCanvasSize(width, height); err != nil {
+			return nil, err
+		}
+
+		images, err := getMontageImages(ctx, sources, NewFitResizeImage(opts.CellWidth, opts.CellHeight), concurrency)
+		if err != nil {
+			return nil, err
+		}
+		canvas := imaging.New(width, height, background)
+		textColor := labelColor(background)
+		for index, img := range images {
+			x := opts.Spacing + (index%columns)*(opts.CellWidth+opts.Spacing)
+			y := opts.Spacing + (index/columns)*(rowHeight+opts.Spacing)
+			// 图片在单元格中居中
+			pt := image.Pt(x+(opts.CellWidth-img.Width())/2, y+(opts.CellHeight-img.Height())/2)
+			canvas = imaging.Overlay(canvas, img.grid, pt, 1)
+			if index < len(opts.Labels) && labelHeight != 0 {
+				r := image.Rect(x, y+opts.CellHeight+labelPadding/2, x+opts.CellWidth, y+rowHeight)
+				drawLabel(canvas, opts.Labels[index], r, textColor)
+			}
+		}
+		return &Image{
+			grid:         canvas,
+			format:       ImageTypePNG,
+			historyDepth: getDefaultHistoryDepth(),
+		}, nil
+	}
+}
+
+// parseMontage parses the montage task, the sources are separated by `,`,
+// e.g. `montage(minioFinder/bucket/a.png,minioFinder/bucket/b.png)/4/200/200/10/white/label`
+func parseMontage(task, accept string) (Job, error) {
+	start := len(TaskMontage)
+	end := findCloseParen(task, start)
+	if end < 0 {
+		return nil, errors.New("sources of montage task are invalid")
+	}
+	rest := task[end+1:]
+	if !strings.HasPrefix(rest, "/") {
+		return nil, errors.New("cell size of montage task can not be nil")
+	}
+	params := strings.Split(strings.TrimPrefix(rest, "/"), "/")
+	values, err := parseIntParams(params, 3)
+	if err != nil {
+		return nil, err
+	}
+	opts := MontageOptions{
+		Columns:    values[0],
+		CellWidth:  values[1],
+		CellHeight: values[2],
+	}
+	if len(params) > 3 && params[3] != "" {
+		opts.Spacing, err = strconv.Atoi(params[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+	background, err := parseColorParam(params, 4, colorWhite)
+	if err != nil {
+		return nil, err
+	}
+	opts.Background = background
+	withLabel := len(params) > 5 && params[5] == "label"
+
+	pipelines := splitTasks(task[start+1:end], ',')
+	sources := make([]Job, 0, len(pipelines))
+	for _, pipeline := range pipelines {
+		if err := checkSourcePipeline(TaskMontage, pipeline); err != nil {
+			return nil, err
+		}
+		jobs, err := Parse(pipeline, accept)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, NewPipelineJob(jobs...))
+		if withLabel {
+			// 以图片的名称为label
+			arr := strings.Split(strings.Split(pipeline, "|")[0], "/")
+			opts.Labels = append(opts.Labels, arr[len(arr)-1])
+		}
+	}
+	return NewMontageImage(sources, opts), nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelColor(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(colorBlack, labelColor(colorWhite))
+	assert.Equal(colorWhite, labelColor(colorBlack))
+	assert.Equal(colorBlack, labelColor(color.Transparent))
+}
+
+func TestNewMontageImage(t *testing.T) {
+	assert := assert.New(t)
+
+	red := color.NRGBA{R: 255, A: 255}
+	newSource := func(width, height int) Job {
+		return func(_ context.Context, _ *Image) (*Image, error) {
+			return newColorImage(width, height, red), nil
+		}
+	}
+	sources := []Job{
+		newSource(100, 100),
+		newSource(200, 100),
+		newSource(10, 10),
+	}
+	img, err := NewMontageImage(sources, MontageOptions{
+		Columns:    2,
+		CellWidth:  50,
+		CellHeight: 50,
+		Spacing:    10,
+		Background: colorBlack,
+		Labels: []string{
+			"a.png",
+			"b.png",
+		},
+	})(context.Background(), nil)
+	assert.Nil(err)
+	labelHeight := labelFace.Height + labelPadding
+	assert.Equal(2*50+3*10, img.Width())
+	assert.Equal(2*(50+labelHeight)+3*10, img.Height())
+	assert.Equal(ImageTypePNG, img.format)
+
+	grid := imaging.Clone(img.grid)
+	assert.Equal(colorBlack, grid.NRGBAAt(5, 5))
+	assert.Equal(red, grid.NRGBAAt(10, 10))
+	assert.Equal(red, grid.NRGBAAt(59, 59))
+	// 200x100缩放为50x25，居中放置
+	assert.Equal(colorBlack, grid.NRGBAAt(70, 20))
+	assert.Equal(red, grid.NRGBAAt(70, 30))
+	// label为白色
+	hasLabel := false
+	for y := 60; y < 60+labelHeight; y++ {
+		for x := 10; x < 60; x++ {
+			if grid.NRGBAAt(x, y) == colorWhite {
+				hasLabel = true
+			}
+		}
+	}
+	assert.True(hasLabel)
+
+	sourceErr := wrapError(ErrNotFound, errors.New("image is not found"))
+	_, err = NewMontageImage([]Job{
+		newSource(10, 10),
+		func(_ context.Context, _ *Image) (*Image, error) {
+			return nil, sourceErr
+		},
+	}, MontageOptions{
+		CellWidth:  50,
+		CellHeight: 50,
+	})(context.Background(), nil)
+	assert.Equal(sourceErr, err)
+
+	_, err = NewMontageImage(nil, MontageOptions{})(context.Background(), nil)
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = NewMontageImage(sources, MontageOptions{})(context.Background(), nil)
+	assert.True(errors.Is(err, ErrInvalidParam))
+
+	// 画布过大
+	_, err = NewMontageImage(sources, MontageOptions{
+		Columns:    3,
+		CellWidth:  5000,
+		CellHeight: 50,
+	})(context.Background(), nil)
+	assert.True(errors.Is(err, ErrTooLarge))
+
+	// 空图片返回出错
+	_, err = NewMontageImage([]Job{
+		func(_ context.Context, _ *Image) (*Image, error) {
+			return nil, nil
+		},
+	}, MontageOptions{
+		CellWidth:  50,
+		CellHeight: 50,
+	})(context.Background(), nil)
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
+
+func TestMontageConcurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	mutex := sync.Mutex{}
+	running := 0
+	maxRunning := 0
+	count := 0
+	sourceErr := errors.New("source fail")
+	newSource := func(fail bool) Job {
+		return func(ctx context.Context, _ *Image) (*Image, error) {
+			mutex.Lock()
+			count++
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			defer func() {
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+			}()
+			if fail {
+				return nil, sourceErr
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+			}
+			return newColorImage(10, 10, colorBlack), nil
+		}
+	}
+	sources := make([]Job, 0)
+	for i := 0; i < 8; i++ {
+		sources = append(sources, newSource(false))
+	}
+	img, err := NewMontageImage(sources, MontageOptions{
+		CellWidth:   10,
+		CellHeight:  10,
+		Concurrency: 2,
+	})(context.Background(), nil)
+	assert.Nil(err)
+	assert.NotNil(img)
+	assert.Equal(2, maxRunning)
+
+	// 出错后不再获取后续的图片
+	count = 0
+	sources = append([]Job{newSource(true)}, sources...)
+	_, err = NewMontageImage(sources, MontageOptions{
+		CellWidth:   10,
+		CellHeight:  10,
+		Concurrency: 1,
+	})(context.Background(), nil)
+	assert.Equal(sourceErr, err)
+	assert.Equal(1, count)
+}
+
+func TestParseMontage(t *testing.T) {
+	assert := assert.New(t)
+
+	basePath, err := ioutil.TempDir("", "montage")
+	assert.Nil(err)
+	defer os.RemoveAll(basePath)
+	for _, file := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		err = ioutil.WriteFile(filepath.Join(basePath, file), newImageData(), 0600)
+		assert.Nil(err)
+	}
+	assert.Nil(AddFileFinder("montageFinder", basePath))
+
+	jobs, err := Parse("montage(montageFinder/a.jpg,montageFinder/b.jpg|trim,montageFinder/c.jpg)/2/100/80/5/fff/label", "")
+	assert.Nil(err)
+	img, err := Do(context.Background(), nil, jobs...)
+	assert.Nil(err)
+	assert.Equal(2*100+3*5, img.Width())
+	assert.Equal(2*(80+labelFace.Height+labelPadding)+3*5, img.Height())
+
+	jobs, err = Parse("montage(montageFinder/a.jpg,montageFinder/d.jpg)/2/100/80", "")
+	assert.Nil(err)
+	_, err = Do(context.Background(), nil, jobs...)
+	assert.True(errors.Is(err, ErrNotFound))
+
+	for _, task := range []string{
+		"montage(montageFinder/a.jpg",
+		"montage(montageFinder/a.jpg)",
+		"montage(montageFinder/a.jpg)/2/100",
+		"montage(montageFinder/a.jpg)/2/100/80/a",
+		"montage(montageFinder/a.jpg)/2/100/80/5/xyz",
+		"montage(notExistsFinder/a.jpg)/2/100/80",
+		"montage(fitResize/10/10)/1/10/10",
+		"montage(montageFinder/a.jpg,trim)/2/100/80",
+	} {
+		_, err = Parse(task, "")
+		assert.True(errors.Is(err, ErrInvalidParam), task)
+	}
+}
@@ -16,107 +16,130 @@ package imagepipeline
 
 import (
 	"context"
-	"errors"
-	"strings"
-	"sync"
-
-	"github.com/vicanso/tiny/pb"
-	"golang.org/x/sync/singleflight"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
-var grpcSF = singleflight.Group{}
-
-var grpcConnections = sync.Map{}
-
-var ErrGRPCClientInvalid = errors.New("grpc client connection is invalid")
+// OptimizeStat is the stat of optimize job, it is set to the job step of report
+type OptimizeStat struct {
+	// SourceFormat is the format of data sent to optimizer
+	SourceFormat string
+	// Reencoded is true if the image is encoded to png before sending,
+	// because the grid has been changed
+	Reencoded bool
+	// SourceSize is the size of data sent to optimizer
+	SourceSize int
+	// OutputSize is the size of optimized data
+	OutputSize int
+	// KeptSource is true if the optimized data is not smaller than the source,
+	// and the source data is kept, only the original data of acceptable format is kept
+	KeptSource bool
+	// Quality is the quality of optimized data
+	Quality int
+	// Attempts is the count of optimizing, it is more than 1 for searching quality
+	Attempts int
+}
 
-func convertToConnection(value interface{}) (*grpc.ClientConn, error) {
-	c, _ := value.(*grpc.ClientConn)
-	if c == nil {
-		return nil, ErrGRPCClientInvalid
+// Saved returns the bytes saved by optimizer
+func (s *OptimizeStat) Saved() int {
+	if s.KeptSource {
+		return 0
 	}
-	return c, nil
+	return s.SourceSize - s.OutputSize
 }
 
-func newTinyConnection(ctx context.Context, addr string) (*grpc.ClientConn, error) {
-	value, ok := grpcConnections.Load(addr)
-	if ok {
-		return convertToConnection(value)
+// optimizeSource returns the data sent to optimizer,
+// the original bytes are used if the grid is not changed,
+// otherwise the image is encoded to png(lossless)
+func optimizeSource(img *Image) ([]byte, string, error) {
+	data, format := img.Bytes()
+	if len(data) != 0 && isOptimizeFormat(format) {
+		return data, format, nil
 	}
-	value, err, _ := grpcSF.Do(addr, func() (interface{}, error) {
-		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		if err != nil {
-			return nil, err
-		}
-		grpcConnections.Store(addr, conn)
-		return conn, nil
-	})
+	data, err := img.PNG()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return convertToConnection(value)
+	return data, ImageTypePNG, nil
 }
 
-func optimize(ctx context.Context, addr string, img *Image, quality int, format string) (*Image, error) {
-	c, err := newTinyConnection(ctx, addr)
-	if err != nil {
-		return nil, err
+// newOptimizedImage returns the image of optimized data, the source data is kept
+// if it is the original data, its format is acceptable and the optimized data is not smaller than it.
+// The source format is acceptable if it is the output format or accepted returns true.
+// The stat is set to the job step.
+func newOptimizedImage(ctx context.Context, img *Image, source []byte, sourceFormat string, data []byte, format string, accepted func(string) bool, stat OptimizeStat) *Image {
+	reencoded := len(img.optimizedData) == 0 || img.format != sourceFormat
+	acceptable := sourceFormat == format || (accepted != nil && accepted(sourceFormat))
+	// 压缩后的数据不小于原数据时，保留原数据(重新编码的png并非原数据)
+	keptSource := !reencoded && acceptable && len(data) >= len(source)
+	if step := getJobStep(ctx); step != nil {
+		stat.SourceFormat = sourceFormat
+		stat.Reencoded = reencoded
+		stat.SourceSize = len(source)
+		stat.OutputSize = len(data)
+		stat.KeptSource = keptSource
+		step.Optimize = &stat
 	}
-	data, err := img.PNG()
-	if err != nil {
-		return nil, err
+	if keptSource {
+		return img.withOptimized(source, sourceFormat)
 	}
+	return img.withOptimized(data, format)
+}
 
-	client := pb.NewOptimClient(c)
-	in := pb.OptimRequest{
-		Data:    data,
-		Source:  pb.Type_PNG,
-		Quality: uint32(quality),
-	}
-	switch format {
-	case ImageTypePNG:
-		in.Output = pb.Type_PNG
-	case ImageTypeWEBP:
-		in.Output = pb.Type_WEBP
-	case ImageTypeAVIF:
-		in.Output = pb.Type_AVIF
-	default:
-		in.Output = pb.Type_JPEG
+func optimize(ctx context.Context, optimizer Optimizer, img *Image, quality int, format string, accepted func(string) bool) (*Image, error) {
+	source, sourceFormat, err := optimizeSource(img)
+	if err != nil {
+		return nil, err
 	}
-	reply, err := client.DoOptim(ctx, &in)
+	data, err := optimizer.Optimize(ctx, &OptimizeParams{
+		Data:         source,
+		SourceFormat: sourceFormat,
+		Grid:         img.grid,
+		Quality:      quality,
+		Format:       format,
+	})
 	if err != nil {
 		return nil, err
 	}
-	img.setOptimized(reply.Data, format)
-	return img, nil
+	return newOptimizedImage(ctx, img, source, sourceFormat, data, format, accepted, OptimizeStat{
+		Quality:  quality,
+		Attempts: 1,
+	}), nil
 }
 
-// NewAutoOptimizeImage creates an optimize image job, which will find the match type for optimizing by accept
-func NewAutoOptimizeImage(addr string, quality int, accept string) Job {
+// NewAutoOptimizeImage creates an optimize image job, which will find the match type for optimizing by accept,
+// see NegotiateFormat for more details. If none of the preferred formats is acceptable,
+// or the original format is not acceptable, the format is selected by the content of image:
+// flat graphics use PNG-8, transparent images keep alpha-capable format(png if it is not accepted)
+// and photographic images use jpeg.
+func NewAutoOptimizeImage(optimizer Optimizer, quality int, accept string) Job {
 	return func(ctx context.Context, img *Image) (*Image, error) {
-		format := img.format
-
-		if strings.Contains(accept, "image/avif") {
-			format = ImageTypeAVIF
-		} else if strings.Contains(accept, "image/webp") {
-			format = ImageTypeWEBP
-			if format == ImageTypePNG {
-				quality = 0
-			}
+		format := NegotiateFormat(accept, img.format)
+		if format == "" || (format == img.format && !isPreferredFormat(format)) {
+			img, format = selectContentFormat(img, accept)
+		}
+		q := quality
+		// png转换为webp时使用无损压缩
+		if img.format == ImageTypePNG && format == ImageTypeWEBP {
+			q = 0
+		}
+		// 原数据的格式可被客户端接受时才可保留
+		result, err := optimize(ctx, optimizer, img, q, format, func(sourceFormat string) bool {
+			return isAcceptedFormat(accept, sourceFormat)
+		})
+		if err != nil {
+			return nil, err
 		}
-		return optimize(ctx, addr, img, quality, format)
+		result.varyAccept = true
+		return result, nil
 	}
 }
 
 // NewOptimizeImage creates an optimize image job, it the format is nil, the original format will be used
-func NewOptimizeImage(addr string, quality int, formats ...string) Job {
+func NewOptimizeImage(optimizer Optimizer, quality int, formats ...string) Job {
 	return func(ctx context.Context, img *Image) (*Image, error) {
 		format := img.format
 		if len(formats) != 0 {
 			format = formats[0]
 		}
-		return optimize(ctx, addr, img, quality, format)
+		return optimize(ctx, optimizer, img, quality, format, nil)
 	}
 }
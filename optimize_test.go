@@ -19,12 +19,19 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny/pb"
 )
 
 func TestNewAutoOptimizeImage(t *testing.T) {
 	assert := assert.New(t)
 
-	fn := NewAutoOptimizeImage("127.0.0.1:6002", 80, "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
+	optimizer, err := NewTinyOptimizer(TinyOptions{
+		Addrs: []string{
+			"127.0.0.1:6002",
+		},
+	})
+	assert.Nil(err)
+	fn := NewAutoOptimizeImage(optimizer, 80, "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8")
 
 	img, err := NewImageFromBytes(newImageData())
 	assert.Nil(err)
@@ -38,7 +45,13 @@ func TestNewAutoOptimizeImage(t *testing.T) {
 func TestNewOptimizeImage(t *testing.T) {
 	assert := assert.New(t)
 
-	fn := NewOptimizeImage("127.0.0.1:6002", 80, ImageTypePNG)
+	optimizer, err := NewTinyOptimizer(TinyOptions{
+		Addrs: []string{
+			"127.0.0.1:6002",
+		},
+	})
+	assert.Nil(err)
+	fn := NewOptimizeImage(optimizer, 80, ImageTypePNG)
 
 	img, err := NewImageFromBytes(newImageData())
 	assert.Nil(err)
@@ -48,3 +61,123 @@ func TestNewOptimizeImage(t *testing.T) {
 	assert.Equal(829, img.Width())
 	assert.Equal(846, img.Height())
 }
+
+func TestOptimizeSource(t *testing.T) {
+	assert := assert.New(t)
+
+	optimizer, srv := newTestOptimServer(t)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	// 未修改的图片直接发送原数据
+	jobs := []Job{
+		NewNamedJob(TaskOptimize, NewOptimizeImage(optimizer, 80, ImageTypeWEBP)),
+	}
+	result, report, err := DoWithReport(context.Background(), img, jobs...)
+	assert.Nil(err)
+	in := srv.lastRequest()
+	assert.Equal(pb.Type_JPEG, in.Source)
+	assert.Equal(pb.Type_WEBP, in.Output)
+	assert.Equal(newImageData(), in.Data)
+	assert.Equal(ImageTypeWEBP, result.format)
+	stat := report.Steps[0].Optimize
+	assert.Equal(&OptimizeStat{
+		SourceFormat: ImageTypeJPEG,
+		SourceSize:   len(newImageData()),
+		OutputSize:   len(newImageData()) / 2,
+		Quality:      80,
+		Attempts:     1,
+	}, stat)
+	assert.Equal(len(newImageData())-len(newImageData())/2, stat.Saved())
+
+	// 修改后的图片使用png
+	jobs = []Job{
+		NewFitResizeImage(100, 0),
+		NewNamedJob(TaskOptimize, NewOptimizeImage(optimizer, 80)),
+	}
+	_, report, err = DoWithReport(context.Background(), img, jobs...)
+	assert.Nil(err)
+	in = srv.lastRequest()
+	assert.Equal(pb.Type_PNG, in.Source)
+	assert.Equal(pb.Type_JPEG, in.Output)
+	assert.Nil(report.Steps[0].Optimize)
+	stat = report.Steps[1].Optimize
+	assert.True(stat.Reencoded)
+	assert.Equal(ImageTypePNG, stat.SourceFormat)
+	assert.Equal(len(in.Data), stat.SourceSize)
+}
+
+func TestOptimizeKeepSmallest(t *testing.T) {
+	assert := assert.New(t)
+
+	optimizer, srv := newTestOptimServer(t)
+	srv.setGrow(true)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	result, report, err := DoWithReport(context.Background(), img, NewOptimizeImage(optimizer, 80))
+	assert.Nil(err)
+	data, format := result.Bytes()
+	assert.Equal(newImageData(), data)
+	assert.Equal(ImageTypeJPEG, format)
+	stat := report.Steps[0].Optimize
+	assert.True(stat.KeptSource)
+	assert.Equal(2*len(newImageData()), stat.OutputSize)
+	assert.Equal(0, stat.Saved())
+
+	// 原数据非指定的格式则不保留
+	result, report, err = DoWithReport(context.Background(), img, NewOptimizeImage(optimizer, 80, ImageTypeWEBP))
+	assert.Nil(err)
+	data, format = result.Bytes()
+	assert.Equal(ImageTypeWEBP, format)
+	assert.Equal(2*len(newImageData()), len(data))
+	assert.False(report.Steps[0].Optimize.KeptSource)
+
+	// 客户端可接受原数据的格式则保留
+	result, err = NewAutoOptimizeImage(optimizer, 80, "image/webp,*/*")(context.Background(), img)
+	assert.Nil(err)
+	data, format = result.Bytes()
+	assert.Equal(ImageTypeJPEG, format)
+	assert.Equal(newImageData(), data)
+	result, err = NewAutoOptimizeImage(optimizer, 80, "image/webp")(context.Background(), img)
+	assert.Nil(err)
+	_, format = result.Bytes()
+	assert.Equal(ImageTypeWEBP, format)
+
+	// 图片已修改则编码的png并非原数据，不保留
+	img, err = NewFitResizeImage(100, 0)(context.Background(), img)
+	assert.Nil(err)
+	result, report, err = DoWithReport(context.Background(), img, NewOptimizeImage(optimizer, 80, ImageTypeJPEG))
+	assert.Nil(err)
+	data, format = result.Bytes()
+	assert.Equal(ImageTypeJPEG, format)
+	assert.Equal(2*len(srv.lastRequest().Data), len(data))
+	stat = report.Steps[0].Optimize
+	assert.True(stat.Reencoded)
+	assert.False(stat.KeptSource)
+}
+
+func TestAutoOptimizeNegotiate(t *testing.T) {
+	assert := assert.New(t)
+
+	optimizer, srv := newTestOptimServer(t)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	assert.False(img.VaryAccept())
+
+	result, err := NewAutoOptimizeImage(optimizer, 80, "image/avif;q=0,image/webp,*/*")(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(ImageTypeWEBP, result.format)
+	assert.Equal(uint32(80), srv.lastRequest().Quality)
+	assert.True(result.VaryAccept())
+
+	// png转换为webp使用无损压缩
+	data, err := newColorImage(20, 20, colorWhite).PNG()
+	assert.Nil(err)
+	pngImg, err := NewImageFromBytes(data)
+	assert.Nil(err)
+	result, err = NewAutoOptimizeImage(optimizer, 80, "image/webp")(context.Background(), pngImg)
+	assert.Nil(err)
+	assert.Equal(ImageTypeWEBP, result.format)
+	assert.Equal(uint32(0), srv.lastRequest().Quality)
+}
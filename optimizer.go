@@ -0,0 +1,289 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrOptimizerNotFound = errors.New("Optimizer is not found")
+var ErrOptimizerInValid = errors.New("Optimizer is invald")
+
+// OptimizeParams is the params of optimizer
+type OptimizeParams struct {
+	// Data is the source data, it is the original data if the image is not changed,
+	// otherwise it is encoded to png
+	Data []byte
+	// SourceFormat is the format of data
+	SourceFormat string
+	// Grid is the decoded image of data
+	Grid image.Image
+	// Quality is the quality of optimizing, 0 means the default quality of optimizer
+	Quality int
+	// Format is the output format
+	Format string
+}
+
+// Optimizer optimizes the image data, and returns the data of output format
+type Optimizer interface {
+	Optimize(ctx context.Context, params *OptimizeParams) ([]byte, error)
+	Close(ctx context.Context) error
+}
+
+var optimizers = sync.Map{}
+
+// AddOptimizer adds an optimizer with name, the optimizer with the same name will be replaced
+func AddOptimizer(name string, optimizer Optimizer) {
+	optimizers.Store(name, optimizer)
+}
+
+// GetOptimizer returns an optimizer by name
+func GetOptimizer(name string) (Optimizer, error) {
+	value, ok := optimizers.Load(name)
+	if !ok {
+		return nil, ErrOptimizerNotFound
+	}
+	o, ok := value.(Optimizer)
+	if !ok {
+		return nil, ErrOptimizerInValid
+	}
+	return o, nil
+}
+
+// RangeOptimizer calls fn sequentially for each key and optimizer in the optimizers
+func RangeOptimizer(fn func(name string, o Optimizer)) {
+	optimizers.Range(func(key, value interface{}) bool {
+		k, _ := key.(string)
+		o, ok := value.(Optimizer)
+		if ok {
+			fn(k, o)
+		}
+		return true
+	})
+}
+
+// CloseOptimizers closes all the optimizers for graceful shutdown,
+// the first error is returned
+func CloseOptimizers(ctx context.Context) error {
+	var result error
+	closeOptimizer := func(_, value interface{}) bool {
+		o, ok := value.(Optimizer)
+		if !ok {
+			return true
+		}
+		err := o.Close(ctx)
+		if err != nil && result == nil {
+			result = err
+		}
+		return true
+	}
+	optimizers.Range(closeOptimizer)
+	addrOptimizers.Range(func(key, value interface{}) bool {
+		addrOptimizers.Delete(key)
+		return closeOptimizer(key, value)
+	})
+	return result
+}
+
+func isOptimizeFormat(format string) bool {
+	switch format {
+	case ImageTypeJPEG, ImageTypePNG, ImageTypeWEBP, ImageTypeAVIF:
+		return true
+	}
+	return false
+}
+
+type localOptimizer struct{}
+
+// NewLocalOptimizer returns an optimizer which encodes the image with the go encoders,
+// only jpeg and png are supported
+func NewLocalOptimizer() Optimizer {
+	return &localOptimizer{}
+}
+
+func (lo *localOptimizer) Optimize(_ context.Context, params *OptimizeParams) ([]byte, error) {
+	buf := bytes.Buffer{}
+	var err error
+	switch params.Format {
+	case ImageTypeJPEG:
+		quality := params.Quality
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, flatten(params.Grid, colorWhite), &jpeg.Options{
+			Quality: quality,
+		})
+	case ImageTypePNG:
+		encoder := png.Encoder{
+			CompressionLevel: png.BestCompression,
+		}
+		err = encoder.Encode(&buf, params.Grid)
+	default:
+		return nil, wrapError(ErrUnsupportedFormat, errors.New("local optimizer does not support "+params.Format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lo *localOptimizer) Close(_ context.Context) error {
+	return nil
+}
+
+// AddLocalOptimizer adds a local optimizer
+func AddLocalOptimizer(name string) {
+	AddOptimizer(name, NewLocalOptimizer())
+}
+
+const (
+	execInput   = "{input}"
+	execOutput  = "{output}"
+	execQuality = "{quality}"
+)
+
+// ExecCommand is the command of exec optimizer,
+// the args support placeholders: {input}, {output} and {quality}
+type ExecCommand struct {
+	Name string
+	Args []string
+	// DefaultQuality is used if the quality is 0
+	DefaultQuality int
+}
+
+// DefaultExecCommands are the commands for webp and avif
+var DefaultExecCommands = map[string]ExecCommand{
+	ImageTypeWEBP: {
+		Name: "cwebp",
+		Args: []string{
+			"-quiet",
+			"-q",
+			execQuality,
+			execInput,
+			"-o",
+			execOutput,
+		},
+		DefaultQuality: 80,
+	},
+	ImageTypeAVIF: {
+		Name: "avifenc",
+		Args: []string{
+			"-q",
+			execQuality,
+			execInput,
+			execOutput,
+		},
+		DefaultQuality: 60,
+	},
+}
+
+type execOptimizer struct {
+	commands map[string]ExecCommand
+}
+
+// NewExecOptimizer returns an optimizer which runs the command of the output format,
+// the commands are keyed by format, the formats whose command is not present are not supported,
+// and an error is returned if none of the commands is present
+func NewExecOptimizer(commands map[string]ExecCommand) (Optimizer, error) {
+	available := make(map[string]ExecCommand, len(commands))
+	for format, cmd := range commands {
+		// 命令不存在则不支持该格式
+		if _, err := exec.LookPath(cmd.Name); err != nil {
+			continue
+		}
+		available[format] = cmd
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("%w: none of the commands is present", exec.ErrNotFound)
+	}
+	return &execOptimizer{
+		commands: available,
+	}, nil
+}
+
+func (eo *execOptimizer) Optimize(ctx context.Context, params *OptimizeParams) ([]byte, error) {
+	cmd, ok := eo.commands[params.Format]
+	if !ok {
+		return nil, wrapError(ErrUnsupportedFormat, errors.New("exec optimizer does not support "+params.Format))
+	}
+	dir, err := os.MkdirTemp("", "imagepipeline")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// 仅jpeg与png可作为命令的输入
+	data := params.Data
+	sourceFormat := params.SourceFormat
+	if sourceFormat != ImageTypeJPEG && sourceFormat != ImageTypePNG {
+		buf := bytes.Buffer{}
+		err = png.Encode(&buf, params.Grid)
+		if err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+		sourceFormat = ImageTypePNG
+	}
+	input := filepath.Join(dir, "input."+sourceFormat)
+	output := filepath.Join(dir, "output."+params.Format)
+	err = os.WriteFile(input, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+	quality := params.Quality
+	if quality <= 0 {
+		quality = cmd.DefaultQuality
+	}
+	args := make([]string, len(cmd.Args))
+	for index, arg := range cmd.Args {
+		arg = strings.ReplaceAll(arg, execInput, input)
+		arg = strings.ReplaceAll(arg, execOutput, output)
+		args[index] = strings.ReplaceAll(arg, execQuality, strconv.Itoa(quality))
+	}
+	out, err := exec.CommandContext(ctx, cmd.Name, args...).CombinedOutput()
+	if err != nil {
+		return nil, wrapError(ErrUpstream, errors.New(cmd.Name+" fail, "+err.Error()+": "+string(out)))
+	}
+	return os.ReadFile(output)
+}
+
+func (eo *execOptimizer) Close(_ context.Context) error {
+	return nil
+}
+
+// AddExecOptimizer adds an exec optimizer, DefaultExecCommands is used if commands is nil
+func AddExecOptimizer(name string, commands map[string]ExecCommand) error {
+	if commands == nil {
+		commands = DefaultExecCommands
+	}
+	o, err := NewExecOptimizer(commands)
+	if err != nil {
+		return err
+	}
+	AddOptimizer(name, o)
+	return nil
+}
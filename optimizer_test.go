@@ -0,0 +1,167 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOptimizer returns the data of format tag, and records the params
+type fakeOptimizer struct {
+	mutex  sync.Mutex
+	params []*OptimizeParams
+}
+
+func (fo *fakeOptimizer) Optimize(_ context.Context, params *OptimizeParams) ([]byte, error) {
+	fo.mutex.Lock()
+	defer fo.mutex.Unlock()
+	fo.params = append(fo.params, params)
+	return []byte(params.Format), nil
+}
+
+func (fo *fakeOptimizer) Close(_ context.Context) error {
+	return nil
+}
+
+func TestOptimizerRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := GetOptimizer("fake")
+	assert.Equal(ErrOptimizerNotFound, err)
+
+	fake := &fakeOptimizer{}
+	AddOptimizer("fake", fake)
+	defer optimizers.Delete("fake")
+	o, err := GetOptimizer("fake")
+	assert.Nil(err)
+	assert.Equal(fake, o)
+
+	names := make([]string, 0)
+	RangeOptimizer(func(name string, _ Optimizer) {
+		names = append(names, name)
+	})
+	assert.Contains(names, "fake")
+
+	// optimize任务通过名称获取optimizer
+	jobs, err := Parse("optimize/fake/70/webp", "")
+	assert.Nil(err)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	result, err := Do(context.Background(), img, jobs...)
+	assert.Nil(err)
+	data, format := result.Bytes()
+	assert.Equal(ImageTypeWEBP, format)
+	assert.Equal([]byte(ImageTypeWEBP), data)
+	params := fake.params[0]
+	assert.Equal(70, params.Quality)
+	assert.Equal(ImageTypeJPEG, params.SourceFormat)
+	assert.Equal(newImageData(), params.Data)
+	assert.Equal(img.grid, params.Grid)
+}
+
+func TestLocalOptimizer(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	o := NewLocalOptimizer()
+
+	data, err := o.Optimize(context.Background(), &OptimizeParams{
+		Grid:    img.grid,
+		Quality: 50,
+		Format:  ImageTypeJPEG,
+	})
+	assert.Nil(err)
+	assert.True(len(data) < len(newImageData()))
+	_, err = jpeg.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+
+	grid := newColorImage(10, 10, colorWhite).grid
+	data, err = o.Optimize(context.Background(), &OptimizeParams{
+		Grid:   grid,
+		Format: ImageTypePNG,
+	})
+	assert.Nil(err)
+	_, err = png.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+
+	_, err = o.Optimize(context.Background(), &OptimizeParams{
+		Grid:   grid,
+		Format: ImageTypeAVIF,
+	})
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+}
+
+func TestExecOptimizer(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewExecOptimizer(map[string]ExecCommand{
+		ImageTypeWEBP: {
+			Name: "image-pipeline-not-exists",
+		},
+	})
+	assert.True(errors.Is(err, exec.ErrNotFound))
+
+	// 使用cp模拟转换命令，不存在的命令则忽略
+	o, err := NewExecOptimizer(map[string]ExecCommand{
+		ImageTypePNG: {
+			Name: "cp",
+			Args: []string{
+				execInput,
+				execOutput,
+			},
+		},
+		ImageTypeWEBP: {
+			Name: "image-pipeline-not-exists",
+		},
+	})
+	assert.Nil(err)
+
+	data, err := o.Optimize(context.Background(), &OptimizeParams{
+		Data:         newImageData(),
+		SourceFormat: ImageTypeJPEG,
+		Format:       ImageTypePNG,
+	})
+	assert.Nil(err)
+	assert.Equal(newImageData(), data)
+
+	// 非jpeg与png则先转换为png
+	grid := newColorImage(10, 10, colorWhite).grid
+	data, err = o.Optimize(context.Background(), &OptimizeParams{
+		Data:         []byte("webp"),
+		SourceFormat: ImageTypeWEBP,
+		Grid:         grid,
+		Format:       ImageTypePNG,
+	})
+	assert.Nil(err)
+	result, _, err := image.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+	assert.Equal(10, result.Bounds().Dx())
+
+	_, err = o.Optimize(context.Background(), &OptimizeParams{
+		Format: ImageTypeWEBP,
+	})
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+}
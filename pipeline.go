@@ -17,8 +17,8 @@ package imagepipeline
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 )
@@ -50,8 +50,8 @@ var ErrAbortNext = errors.New("abort next")
 // Do runs the pipeline jobs
 func Do(ctx context.Context, img *Image, jobs ...Job) (*Image, error) {
 	var err error
-	for _, fn := range jobs {
-		img, err = fn(ctx, img)
+	for index, fn := range jobs {
+		img, err = doJob(ctx, index, fn, img)
 		if err != nil {
 			// 如果是abort error，则直接返回数据
 			if err == ErrAbortNext {
@@ -78,39 +78,75 @@ func parseProxy(params []string, _ string) (Job, error) {
 	}, nil
 }
 
+// resolveOptimizer returns the registered optimizer by name,
+// if it is not registered, the name is used as the address of tiny only if it is allowed
+func resolveOptimizer(name string) (Optimizer, error) {
+	optimizer, err := GetOptimizer(name)
+	if err == nil {
+		return optimizer, nil
+	}
+	if !isOptimizerAddrAllowed(name) {
+		return nil, fmt.Errorf("%w: %s", err, name)
+	}
+	return getAddrOptimizer(name)
+}
+
 func parseOptimize(params []string, _ string) (Job, error) {
 	if len(params) == 0 {
-		return nil, errors.New("optimize addr can not be nil")
+		return nil, errors.New("optimizer can not be nil")
 	}
-	addr := params[0]
-	if os.Getenv(addr) != "" {
-		addr = os.Getenv(addr)
+	optimizer, err := resolveOptimizer(params[0])
+	if err != nil {
+		return nil, err
 	}
 	quality := 0
-	if len(params) > 1 {
-		quality, _ = strconv.Atoi(params[1])
-	}
 	formats := make([]string, 0)
 
 	if len(params) > 2 {
 		formats = append(formats, params[2])
 	}
-	return NewOptimizeImage(addr, quality, formats...), nil
+	if len(params) > 1 {
+		// 按数据大小或ssim查找质量，如`size:100kb`、`ssim:0.98`
+		switch {
+		case strings.HasPrefix(params[1], searchBySize):
+			size, err := parseByteSize(strings.TrimPrefix(params[1], searchBySize))
+			if err != nil {
+				return nil, err
+			}
+			return NewSearchOptimizeImage(optimizer, SearchOptions{
+				MaxSize: size,
+			}, formats...), nil
+		case strings.HasPrefix(params[1], searchBySSIM):
+			ssim, err := strconv.ParseFloat(strings.TrimPrefix(params[1], searchBySSIM), 64)
+			if err != nil {
+				return nil, err
+			}
+			// avif无法解码，不能计算ssim
+			if len(formats) != 0 && formats[0] == ImageTypeAVIF {
+				return nil, errors.New("ssim search does not support avif")
+			}
+			return NewSearchOptimizeImage(optimizer, SearchOptions{
+				MinSSIM: ssim,
+			}, formats...), nil
+		}
+		quality, _ = strconv.Atoi(params[1])
+	}
+	return NewOptimizeImage(optimizer, quality, formats...), nil
 }
 
 func parseAutoOptimize(params []string, accept string) (Job, error) {
 	if len(params) == 0 {
-		return nil, errors.New("optimize addr can not be nil")
+		return nil, errors.New("optimizer can not be nil")
 	}
-	addr := params[0]
-	if os.Getenv(addr) != "" {
-		addr = os.Getenv(addr)
+	optimizer, err := resolveOptimizer(params[0])
+	if err != nil {
+		return nil, err
 	}
 	quality := 0
 	if len(params) > 1 {
 		quality, _ = strconv.Atoi(params[1])
 	}
-	return NewAutoOptimizeImage(addr, quality, accept), nil
+	return NewAutoOptimizeImage(optimizer, quality, accept), nil
 }
 
 func parseFitResize(params []string, _ string) (Job, error) {
@@ -176,52 +212,184 @@ const (
 	TaskFitResize    = "fitResize"
 	TaskFillResize   = "fillResize"
 	TaskWatermark    = "watermark"
+	TaskIf           = "if"
+	TaskFallback     = "fallback"
+	TaskHistory      = "history"
+	TaskUndo         = "undo"
+	TaskRestore      = "restore"
+	TaskComposite    = "composite"
+	TaskPad          = "pad"
+	TaskBorder       = "border"
+	TaskExtend       = "extend"
+	TaskBackground   = "background"
+	TaskRound        = "round"
+	TaskCircle       = "circle"
+	TaskTrim         = "trim"
+	TaskMontage      = "montage"
+	TaskPixelate     = "pixelate"
+	TaskRedact       = "redact"
+	TaskQuantize     = "quantize"
 )
 
 var taskAlias = map[string]string{}
 
+// processTasks are the tasks which process the input image, other tasks(finder, proxy, fallback and montage)
+// get the image without input
+var processTasks = map[string]bool{
+	TaskOptimize:     true,
+	TaskAutoOptimize: true,
+	TaskFitResize:    true,
+	TaskFillResize:   true,
+	TaskWatermark:    true,
+	TaskIf:           true,
+	TaskHistory:      true,
+	TaskUndo:         true,
+	TaskRestore:      true,
+	TaskComposite:    true,
+	TaskPad:          true,
+	TaskBorder:       true,
+	TaskExtend:       true,
+	TaskBackground:   true,
+	TaskRound:        true,
+	TaskCircle:       true,
+	TaskTrim:         true,
+	TaskPixelate:     true,
+	TaskRedact:       true,
+	TaskQuantize:     true,
+}
+
+// isSourceTask returns true if the task gets the image without input
+func isSourceTask(task string) bool {
+	name := strings.Split(task, "/")[0]
+	if index := strings.IndexByte(name, '('); index >= 0 {
+		name = name[:index]
+	}
+	if value, ok := taskAlias[name]; ok {
+		name = strings.Split(value, "/")[0]
+	}
+	return !processTasks[name]
+}
+
+// checkSourcePipeline checks the pipeline of source(e.g. composite, montage),
+// the input image of source is nil, so the first task should get the image
+func checkSourcePipeline(name, pipeline string) error {
+	if !isSourceTask(splitTasks(pipeline, '|')[0]) {
+		return fmt.Errorf("source of %s should begin with finder, %s", name, pipeline)
+	}
+	return nil
+}
+
 func TaskAlias(alias, name string) {
 	taskAlias[alias] = name
 }
 
+// splitTasks splits the string by sep, the sep in parens will be ignored
+func splitTasks(s string, sep byte) []string {
+	result := make([]string, 0)
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, s[start:])
+}
+
+// parseTask parses the task to job, and returns the name of task
+func parseTask(task, accept string) (string, Job, error) {
+	if strings.HasPrefix(task, TaskIf+"(") {
+		job, err := parseIf(task, accept)
+		return TaskIf, job, err
+	}
+	if strings.HasPrefix(task, TaskFallback+"(") {
+		job, err := parseFallback(task, accept)
+		return TaskFallback, job, err
+	}
+	if strings.HasPrefix(task, TaskComposite+"(") {
+		job, err := parseComposite(task, accept)
+		return TaskComposite, job, err
+	}
+	if strings.HasPrefix(task, TaskMontage+"(") {
+		job, err := parseMontage(task, accept)
+		return TaskMontage, job, err
+	}
+	var fn Parser
+	sep := "/"
+	arr := strings.Split(task, sep)
+	value, ok := taskAlias[arr[0]]
+	if ok {
+		arr[0] = value
+		arr = strings.Split(strings.Join(arr, sep), sep)
+	}
+	name := arr[0]
+	args := arr[1:]
+	switch name {
+	case TaskProxy:
+		fn = parseProxy
+	case TaskOptimize:
+		fn = parseOptimize
+	case TaskAutoOptimize:
+		fn = parseAutoOptimize
+	case TaskFitResize:
+		fn = parseFitResize
+	case TaskFillResize:
+		fn = parseFillResize
+	case TaskWatermark:
+		fn = parseWatermark
+	case TaskHistory:
+		fn = parseHistory
+	case TaskUndo:
+		fn = parseUndo
+	case TaskRestore:
+		fn = parseRestore
+	case TaskPad:
+		fn = parsePad
+	case TaskBorder:
+		fn = parseBorder
+	case TaskExtend:
+		fn = parseExtend
+	case TaskBackground:
+		fn = parseBackground
+	case TaskRound:
+		fn = parseRound
+	case TaskCircle:
+		fn = parseCircle
+	case TaskTrim:
+		fn = parseTrim
+	case TaskPixelate:
+		fn = parsePixelate
+	case TaskRedact:
+		fn = parseRedact
+	case TaskQuantize:
+		fn = parseQuantize
+	default:
+		// finder的参数为所有参数
+		args = arr
+		fn = parseFinder
+	}
+	job, err := fn(args, accept)
+	return name, job, err
+}
+
 // Parse parses the task pipe line to job list
 func Parse(taskPipeLine, accept string) ([]Job, error) {
-	tasks := strings.Split(taskPipeLine, "|")
+	tasks := splitTasks(taskPipeLine, '|')
 	jobs := make([]Job, 0, len(tasks))
-	sep := "/"
-	for _, v := range tasks {
-		var fn Parser
-		arr := strings.Split(v, sep)
-		value, ok := taskAlias[arr[0]]
-		if ok {
-			arr[0] = value
-			arr = strings.Split(strings.Join(arr, sep), sep)
-		}
-		name := arr[0]
-		args := arr[1:]
-		switch name {
-		case TaskProxy:
-			fn = parseProxy
-		case TaskOptimize:
-			fn = parseOptimize
-		case TaskAutoOptimize:
-			fn = parseAutoOptimize
-		case TaskFitResize:
-			fn = parseFitResize
-		case TaskFillResize:
-			fn = parseFillResize
-		case TaskWatermark:
-			fn = parseWatermark
-		default:
-			// finder的参数为所有参数
-			args = arr
-			fn = parseFinder
-		}
-		job, err := fn(args, accept)
+	for index, v := range tasks {
+		name, job, err := parseTask(v, accept)
 		if err != nil {
-			return nil, err
+			return nil, withTask(wrapError(ErrInvalidParam, err), index, name)
 		}
-		jobs = append(jobs, job)
+		jobs = append(jobs, NewNamedJob(name, job))
 	}
 	return jobs, nil
 }
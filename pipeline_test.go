@@ -15,6 +15,7 @@
 package imagepipeline
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -32,7 +33,28 @@ func TestParseProxy(t *testing.T) {
 func TestParseOptimize(t *testing.T) {
 	assert := assert.New(t)
 
+	AddLocalOptimizer("local")
+	defer optimizers.Delete("local")
 	_, err := parseOptimize([]string{
+		"local",
+		"90",
+		"png",
+	}, "")
+	assert.Nil(err)
+
+	// 未注册的地址不可使用
+	_, err = parseOptimize([]string{
+		"127.0.0.1:6002",
+	}, "")
+	assert.True(errors.Is(err, ErrOptimizerNotFound))
+	assert.Equal("Optimizer is not found: 127.0.0.1:6002", err.Error())
+	_, err = Parse("optimize/TINY_ADDR/80", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	assert.True(errors.Is(err, ErrOptimizerNotFound))
+
+	AllowOptimizerAddrs("127.0.0.1:6002")
+	defer allowedOptimizerAddrs.Delete("127.0.0.1:6002")
+	_, err = parseOptimize([]string{
 		"127.0.0.1:6002",
 		"90",
 		"png",
@@ -43,11 +65,18 @@ func TestParseOptimize(t *testing.T) {
 func TestParseAutoOptimize(t *testing.T) {
 	assert := assert.New(t)
 
+	AllowOptimizerAddrs("127.0.0.1:6002")
+	defer allowedOptimizerAddrs.Delete("127.0.0.1:6002")
 	_, err := parseAutoOptimize([]string{
 		"127.0.0.1:6002",
 		"80",
 	}, "image/avif,image/webp")
 	assert.Nil(err)
+
+	_, err = parseAutoOptimize([]string{
+		"unknown",
+	}, "image/avif,image/webp")
+	assert.True(errors.Is(err, ErrOptimizerNotFound))
 }
 
 func TestParseFitResize(t *testing.T) {
@@ -79,3 +108,13 @@ func TestParseWatermark(t *testing.T) {
 	}, "")
 	assert.Nil(err)
 }
+
+func TestSplitTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{
+		"fileFinder/a.png",
+		"if(width>100||height>100)fitResize/100/100",
+		"fillResize/50/50",
+	}, splitTasks("fileFinder/a.png|if(width>100||height>100)fitResize/100/100|fillResize/50/50", '|'))
+}
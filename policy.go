@@ -0,0 +1,190 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The errors of url policy, they are categorized as ErrForbidden
+var (
+	ErrURLSchemeNotAllowed = errors.New("url scheme is not allowed")
+	ErrURLHostNotAllowed   = errors.New("url host is not allowed")
+	ErrURLIPNotAllowed     = errors.New("ip address is not allowed")
+	ErrTooManyRedirects    = errors.New("too many redirects")
+	// ErrURLPolicyNotEnforced is returned by NewFetcher if the ip addresses can not be checked
+	// by the transport of client
+	ErrURLPolicyNotEnforced = errors.New("url policy can not be enforced by the transport of client")
+)
+
+const defaultMaxRedirects = 5
+
+// 运营商级NAT的地址段
+var sharedAddressSpace = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+// URLPolicy is the policy of fetching url
+type URLPolicy struct {
+	// Schemes are the allowed schemes, default is http and https
+	Schemes []string
+	// AllowHosts are the allowed hosts, all hosts are allowed if it is empty.
+	// The host matches itself and its sub domains, e.g. `example.com` matches `img.example.com`
+	AllowHosts []string
+	// DenyHosts are the denied hosts, it has higher priority than AllowHosts
+	DenyHosts []string
+	// AllowPrivateIP allows the private, loopback, link-local and unspecified ip addresses,
+	// they are checked after DNS resolution, so the redirects and the hosts resolved to them are also blocked
+	AllowPrivateIP bool
+	// MaxRedirects is the max count of redirects, default is 5, -1 means no redirect
+	MaxRedirects int
+}
+
+// DefaultURLPolicy is the policy of default fetcher: http and https are allowed,
+// private ip addresses are blocked and the max count of redirects is 5
+var DefaultURLPolicy = &URLPolicy{}
+
+type urlPolicyKey struct{}
+
+func withURLPolicy(ctx context.Context, policy *URLPolicy) context.Context {
+	return context.WithValue(ctx, urlPolicyKey{}, policy)
+}
+
+func getURLPolicy(ctx context.Context) *URLPolicy {
+	policy, _ := ctx.Value(urlPolicyKey{}).(*URLPolicy)
+	return policy
+}
+
+func newForbiddenError(err error, value string) error {
+	return wrapError(ErrForbidden, fmt.Errorf("%w: %s", err, value))
+}
+
+func matchHost(host string, hosts []string) bool {
+	for _, item := range hosts {
+		item = strings.ToLower(strings.TrimPrefix(item, "."))
+		if host == item || strings.HasSuffix(host, "."+item) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateIP returns true if the ip is private, loopback, link-local or unspecified
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() ||
+		ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// CheckURL checks the scheme and host of url, and the ip if the host is an ip address
+func (p *URLPolicy) CheckURL(u *url.URL) error {
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{
+			"http",
+			"https",
+		}
+	}
+	allowed := false
+	for _, scheme := range schemes {
+		if strings.EqualFold(scheme, u.Scheme) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return newForbiddenError(ErrURLSchemeNotAllowed, u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" ||
+		matchHost(host, p.DenyHosts) ||
+		(len(p.AllowHosts) != 0 && !matchHost(host, p.AllowHosts)) {
+		return newForbiddenError(ErrURLHostNotAllowed, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+// CheckIP checks the ip address
+func (p *URLPolicy) CheckIP(ip net.IP) error {
+	if !p.AllowPrivateIP && isPrivateIP(ip) {
+		return newForbiddenError(ErrURLIPNotAllowed, ip.String())
+	}
+	return nil
+}
+
+// checkRedirect checks the count of redirects and the url of redirect request
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	policy := getURLPolicy(req.Context())
+	if policy == nil {
+		// 与http.Client默认的限制一致
+		if len(via) >= 10 {
+			return newForbiddenError(ErrTooManyRedirects, req.URL.String())
+		}
+		return nil
+	}
+	maxRedirects := policy.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if len(via) > maxRedirects || maxRedirects < 0 {
+		return newForbiddenError(ErrTooManyRedirects, req.URL.String())
+	}
+	return policy.CheckURL(req.URL)
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newPolicyDialContext returns a dial function which resolves the host and checks the ip addresses,
+// the checked ip address is dialed directly, so the DNS rebinding is also avoided
+func newPolicyDialContext(dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		policy := getURLPolicy(ctx)
+		if policy == nil {
+			return dial(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			err = policy.CheckIP(ip.IP)
+			if err == nil {
+				return dial(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			}
+		}
+		if err == nil {
+			err = newForbiddenError(ErrURLIPNotAllowed, host)
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLPolicyCheckURL(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &URLPolicy{
+		AllowHosts: []string{
+			"example.com",
+			"127.0.0.1",
+		},
+		DenyHosts: []string{
+			"internal.example.com",
+		},
+	}
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://example.com/a.png", nil},
+		{"http://img.example.com/a.png", nil},
+		{"ftp://example.com/a.png", ErrURLSchemeNotAllowed},
+		{"file:///etc/passwd", ErrURLSchemeNotAllowed},
+		{"https://notexample.com/a.png", ErrURLHostNotAllowed},
+		{"https://a.internal.example.com/a.png", ErrURLHostNotAllowed},
+		{"http://127.0.0.1/a.png", ErrURLIPNotAllowed},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		assert.Nil(err)
+		err = policy.CheckURL(u)
+		if tt.err == nil {
+			assert.Nil(err, tt.url)
+			continue
+		}
+		assert.True(errors.Is(err, tt.err), tt.url)
+		assert.True(errors.Is(err, ErrForbidden), tt.url)
+	}
+
+	for _, ip := range []string{
+		"127.0.0.1",
+		"10.1.1.1",
+		"172.16.0.1",
+		"192.168.1.1",
+		"169.254.169.254",
+		"100.64.0.1",
+		"0.0.0.0",
+		"::1",
+		"fe80::1",
+		"fd00::1",
+	} {
+		assert.True(errors.Is(DefaultURLPolicy.CheckIP(net.ParseIP(ip)), ErrURLIPNotAllowed), ip)
+	}
+	assert.Nil(DefaultURLPolicy.CheckIP(net.ParseIP("8.8.8.8")))
+	assert.Nil(allowPrivatePolicy.CheckIP(net.ParseIP("127.0.0.1")))
+}
+
+func TestFetcherURLPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /redirect/3 重定向3次后返回图片
+		if strings.HasPrefix(r.URL.Path, "/redirect/") {
+			count, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+			if count > 0 {
+				http.Redirect(w, r, "/redirect/"+strconv.Itoa(count-1), http.StatusFound)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(newImageData())
+	}))
+	defer s.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	assert.Nil(err)
+
+	// 本机地址默认不可访问
+	_, err = FetchImageFromURL(context.Background(), s.URL+"/image")
+	assert.True(errors.Is(err, ErrURLIPNotAllowed))
+	jobs, err := Parse("proxy/"+url.QueryEscape(s.URL+"/image"), "")
+	assert.Nil(err)
+	_, err = Do(context.Background(), nil, jobs...)
+	assert.True(errors.Is(err, ErrForbidden))
+
+	// 域名解析后再检测ip
+	_, err = FetchImageFromURL(context.Background(), "http://localhost:"+port+"/image")
+	assert.True(errors.Is(err, ErrURLIPNotAllowed))
+
+	// 通过自定义client的transport
+	_, err = mustNewFetcher(FetcherOptions{
+		Client: &http.Client{},
+		Policy: &URLPolicy{
+			AllowHosts: []string{
+				"localhost",
+			},
+		},
+	}).Fetch(context.Background(), "http://localhost:"+port+"/image")
+	assert.True(errors.Is(err, ErrURLIPNotAllowed))
+
+	f := mustNewFetcher(FetcherOptions{
+		Policy: &URLPolicy{
+			AllowPrivateIP: true,
+			MaxRedirects:   2,
+		},
+	})
+	img, err := f.Fetch(context.Background(), s.URL+"/redirect/2")
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+	_, err = f.Fetch(context.Background(), s.URL+"/redirect/3")
+	assert.True(errors.Is(err, ErrTooManyRedirects))
+
+	// 重定向的地址也需要检测
+	f = mustNewFetcher(FetcherOptions{
+		Policy: &URLPolicy{
+			AllowPrivateIP: true,
+			AllowHosts: []string{
+				"localhost",
+			},
+		},
+	})
+	_, err = f.Fetch(context.Background(), "http://localhost:"+port+"/redirect/0")
+	assert.Nil(err)
+	redirect := httptest.NewServer(http.RedirectHandler(s.URL+"/image", http.StatusFound))
+	defer redirect.Close()
+	_, redirectPort, _ := net.SplitHostPort(strings.TrimPrefix(redirect.URL, "http://"))
+	_, err = f.Fetch(context.Background(), "http://localhost:"+redirectPort+"/")
+	assert.True(errors.Is(err, ErrURLHostNotAllowed))
+
+	// http finder的upstream不检测
+	img, err = fetchTrustedURL(context.Background(), s.URL+"/image")
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+}
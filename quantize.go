@@ -0,0 +1,176 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// 生成调色板时最多采样的像素数
+const maxQuantizeSamples = 1 << 18
+
+type colorBox struct {
+	pixels [][4]uint8
+}
+
+// widestChannel returns the channel which has the max range and the range
+func (b *colorBox) widestChannel() (int, int) {
+	channel := 0
+	maxRange := -1
+	for c := 0; c < 4; c++ {
+		min := 255
+		max := 0
+		for _, p := range b.pixels {
+			v := int(p[c])
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > maxRange {
+			maxRange = max - min
+			channel = c
+		}
+	}
+	return channel, maxRange
+}
+
+func (b *colorBox) average() color.NRGBA {
+	var sum [4]int
+	for _, p := range b.pixels {
+		for c := 0; c < 4; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	count := len(b.pixels)
+	return color.NRGBA{
+		R: uint8(sum[0] / count),
+		G: uint8(sum[1] / count),
+		B: uint8(sum[2] / count),
+		A: uint8(sum[3] / count),
+	}
+}
+
+// medianCut generates the palette of grid with median cut
+func medianCut(grid *image.NRGBA, colors int) color.Palette {
+	total := len(grid.Pix) / 4
+	step := 1
+	if total > maxQuantizeSamples {
+		step = total / maxQuantizeSamples
+	}
+	pixels := make([][4]uint8, 0, total/step+1)
+	for i := 0; i < total; i += step {
+		offset := i * 4
+		pixels = append(pixels, [4]uint8{
+			grid.Pix[offset],
+			grid.Pix[offset+1],
+			grid.Pix[offset+2],
+			grid.Pix[offset+3],
+		})
+	}
+	boxes := []*colorBox{
+		{
+			pixels: pixels,
+		},
+	}
+	for len(boxes) < colors {
+		// 选择颜色范围最大的box拆分
+		index := -1
+		channel := 0
+		maxRange := 0
+		for i, b := range boxes {
+			if len(b.pixels) < 2 {
+				continue
+			}
+			c, r := b.widestChannel()
+			if r > maxRange {
+				index = i
+				channel = c
+				maxRange = r
+			}
+		}
+		if index < 0 {
+			break
+		}
+		b := boxes[index]
+		sort.Slice(b.pixels, func(i, j int) bool {
+			return b.pixels[i][channel] < b.pixels[j][channel]
+		})
+		median := len(b.pixels) / 2
+		boxes[index] = &colorBox{
+			pixels: b.pixels[:median],
+		}
+		boxes = append(boxes, &colorBox{
+			pixels: b.pixels[median:],
+		})
+	}
+	palette := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		if len(b.pixels) != 0 {
+			palette = append(palette, b.average())
+		}
+	}
+	return palette
+}
+
+// quantize converts the grid to paletted image with at most colors,
+// Floyd–Steinberg dithering is used if dither is true
+func quantize(grid image.Image, colors int, dither bool) *image.Paletted {
+	src := imaging.Clone(grid)
+	palette := medianCut(src, colors)
+	dst := image.NewPaletted(src.Bounds(), palette)
+	if dither {
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, src.Bounds().Min)
+	} else {
+		draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	}
+	return dst
+}
+
+// NewQuantizeImage creates a job, which converts the image to paletted image with at most colors(2-256),
+// and the format is changed to png, so it will be encoded as 8-bit png
+func NewQuantizeImage(colors int, dither bool) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if colors < 2 || colors > 256 {
+			return nil, newInvalidParamError("quantize colors should be between 2 and 256")
+		}
+		result := img.WithGrid(quantize(img.grid, colors, dither))
+		result.format = ImageTypePNG
+		return result, nil
+	}
+}
+
+// parseQuantize parses the quantize task, e.g. `quantize/64/dither`
+func parseQuantize(params []string, _ string) (Job, error) {
+	if len(params) == 0 {
+		return nil, errors.New("quantize colors can not be nil")
+	}
+	colors, err := strconv.Atoi(params[0])
+	if err != nil {
+		return nil, err
+	}
+	dither := len(params) > 1 && params[1] == "dither"
+	return NewQuantizeImage(colors, dither), nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedianCut(t *testing.T) {
+	assert := assert.New(t)
+
+	grid := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	grid.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	grid.SetNRGBA(1, 0, color.NRGBA{G: 255, A: 255})
+	grid.SetNRGBA(2, 0, color.NRGBA{B: 255, A: 255})
+	grid.SetNRGBA(3, 0, color.NRGBA{})
+
+	palette := medianCut(grid, 4)
+	assert.Equal(4, len(palette))
+	assert.Contains(palette, color.Color(color.NRGBA{}))
+	assert.Contains(palette, color.Color(color.NRGBA{R: 255, A: 255}))
+
+	// 颜色数少于指定的数量
+	palette = medianCut(imaging.New(10, 10, colorWhite), 16)
+	assert.Equal(color.Palette{colorWhite}, palette)
+}
+
+func TestNewQuantizeImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = NewFitResizeImage(200, 0)(context.Background(), img)
+	assert.Nil(err)
+	rgbaPNG, err := img.PNG()
+	assert.Nil(err)
+
+	for _, dither := range []bool{false, true} {
+		result, err := NewQuantizeImage(32, dither)(context.Background(), img)
+		assert.Nil(err)
+		assert.Equal(ImageTypePNG, result.format)
+		paletted, ok := result.grid.(*image.Paletted)
+		assert.True(ok)
+		assert.True(len(paletted.Palette) <= 32)
+
+		buf, err := result.PNG()
+		assert.Nil(err)
+		assert.True(len(buf) < len(rgbaPNG))
+		decoded, err := png.Decode(bytes.NewReader(buf))
+		assert.Nil(err)
+		// 8-bit png解码为paletted
+		_, ok = decoded.(*image.Paletted)
+		assert.True(ok)
+	}
+
+	// 透明像素保留
+	transparent := newColorImage(10, 10, color.Transparent)
+	result, err := NewQuantizeImage(2, false)(context.Background(), transparent)
+	assert.Nil(err)
+	assert.True(hasAlpha(result.grid))
+
+	_, err = NewQuantizeImage(300, false)(context.Background(), img)
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
+
+func TestParseQuantize(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Parse("quantize/64/dither", "")
+	assert.Nil(err)
+	_, err = Parse("quantize", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("quantize/a", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	RedactBlur     = "blur"
+	RedactPixelate = "pixelate"
+	RedactFill     = "fill"
+)
+
+// RedactOptions is the options of redact
+type RedactOptions struct {
+	// Mode is the redact mode: blur, pixelate or fill, default is blur
+	Mode string
+	// Size is the sigma of blur or the block size of pixelate
+	Size int
+	// Color is the color of fill mode, default is black
+	Color color.Color
+}
+
+var maxRedactSize int32 = 100
+
+// SetMaxRedactSize sets the max sigma of blur and the max block size of pixelate, the default is 100
+func SetMaxRedactSize(size int) {
+	atomic.StoreInt32(&maxRedactSize, int32(size))
+}
+
+func getMaxRedactSize() int {
+	return int(atomic.LoadInt32(&maxRedactSize))
+}
+
+// checkRedactSize returns ErrInvalidParam if the size is larger than the max size,
+// the cost of blur grows with the sigma
+func checkRedactSize(size int) error {
+	if size > getMaxRedactSize() {
+		return newInvalidParamError(fmt.Sprintf("size should not be greater than %d", getMaxRedactSize()))
+	}
+	return nil
+}
+
+// pixelate returns a new image, which is made of the blocks with average color
+func pixelate(grid image.Image, size int) *image.NRGBA {
+	dst := imaging.Clone(grid)
+	if size <= 1 {
+		return dst
+	}
+	w := dst.Bounds().Dx()
+	h := dst.Bounds().Dy()
+	for by := 0; by < h; by += size {
+		for bx := 0; bx < w; bx += size {
+			r := image.Rect(bx, by, bx+size, by+size).Intersect(dst.Bounds())
+			var sum [4]int
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					i := dst.PixOffset(x, y)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(dst.Pix[i+c])
+					}
+				}
+			}
+			count := r.Dx() * r.Dy()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					i := dst.PixOffset(x, y)
+					for c := 0; c < 4; c++ {
+						dst.Pix[i+c] = uint8(sum[c] / count)
+					}
+				}
+			}
+		}
+	}
+	return dst
+}
+
+// NewPixelateImage creates a job, which pixelates the whole image with the block size
+func NewPixelateImage(size int) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if size <= 1 {
+			return img, nil
+		}
+		if err := checkRedactSize(size); err != nil {
+			return nil, err
+		}
+		return img.WithGrid(pixelate(img.grid, size)), nil
+	}
+}
+
+// NewRedactImage creates a job, which redacts the rects of image,
+// the rest of image is not changed
+func NewRedactImage(rects []image.Rectangle, opts RedactOptions) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		if opts.Mode != RedactFill {
+			if err := checkRedactSize(opts.Size); err != nil {
+				return nil, err
+			}
+		}
+		dst := imaging.Clone(img.grid)
+		bounds := dst.Bounds()
+		for _, rect := range rects {
+			r := rect.Add(bounds.Min).Intersect(bounds)
+			if r.Empty() {
+				continue
+			}
+			var region image.Image
+			switch opts.Mode {
+			case RedactFill:
+				var c color.Color = colorBlack
+				if opts.Color != nil {
+					c = opts.Color
+				}
+				region = imaging.New(r.Dx(), r.Dy(), c)
+			case RedactPixelate:
+				size := opts.Size
+				if size <= 0 {
+					size = 10
+				}
+				region = pixelate(imaging.Crop(dst, r), size)
+			default:
+				sigma := float64(opts.Size)
+				if sigma <= 0 {
+					sigma = 10
+				}
+				region = imaging.Blur(imaging.Crop(dst, r), sigma)
+			}
+			dst = imaging.Paste(dst, region, r.Min.Sub(bounds.Min))
+		}
+		return img.WithGrid(dst), nil
+	}
+}
+
+func parsePixelate(params []string, _ string) (Job, error) {
+	values, err := parseIntParams(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRedactSize(values[0]); err != nil {
+		return nil, err
+	}
+	return NewPixelateImage(values[0]), nil
+}
+
+// parseRect parses the rect of `x,y,width,height`
+func parseRect(value string) (image.Rectangle, error) {
+	arr := strings.Split(value, ",")
+	if len(arr) != 4 {
+		return image.Rectangle{}, errors.New("rect is invalid: " + value)
+	}
+	values, err := parseIntParams(arr, 4)
+	if err != nil {
+		return image.Rectangle{}, errors.New("rect is invalid: " + value)
+	}
+	if values[2] <= 0 || values[3] <= 0 {
+		return image.Rectangle{}, errors.New("width and height of rect should be greater than 0: " + value)
+	}
+	return image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3]), nil
+}
+
+// parseRedact parses the redact task, the mode can be `blur`, `blur:20`, `pixelate:16`, `fill` or `fill:red`,
+// e.g. `redact/pixelate:16/10,10,100,50/200,30,40,40`
+func parseRedact(params []string, _ string) (Job, error) {
+	if len(params) < 2 {
+		return nil, errors.New("redact mode and rects can not be nil")
+	}
+	opts := RedactOptions{}
+	arr := strings.SplitN(params[0], ":", 2)
+	opts.Mode = arr[0]
+	switch opts.Mode {
+	case RedactBlur, RedactPixelate:
+		if len(arr) == 2 {
+			size, err := strconv.Atoi(arr[1])
+			if err != nil {
+				return nil, err
+			}
+			if err := checkRedactSize(size); err != nil {
+				return nil, err
+			}
+			opts.Size = size
+		}
+	case RedactFill:
+		if len(arr) == 2 {
+			c, err := ParseColor(arr[1])
+			if err != nil {
+				return nil, err
+			}
+			opts.Color = c
+		}
+	default:
+		return nil, errors.New("redact mode is invalid: " + opts.Mode)
+	}
+	rects := make([]image.Rectangle, 0, len(params)-1)
+	for _, value := range params[1:] {
+		r, err := parseRect(value)
+		if err != nil {
+			return nil, err
+		}
+		rects = append(rects, r)
+	}
+	return NewRedactImage(rects, opts), nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+// newStripeImage returns an image with black and white vertical stripes
+func newStripeImage(width, height int) *Image {
+	grid := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := colorBlack
+			if x%2 == 0 {
+				c = colorWhite
+			}
+			grid.SetNRGBA(x, y, c)
+		}
+	}
+	return &Image{
+		grid:   grid,
+		format: ImageTypePNG,
+	}
+}
+
+func TestPixelate(t *testing.T) {
+	assert := assert.New(t)
+
+	grid := pixelate(newStripeImage(10, 10).grid, 4)
+	c := grid.NRGBAAt(0, 0)
+	assert.Equal(c, grid.NRGBAAt(3, 3))
+	assert.Equal(uint8(127), c.R)
+	// 最后一块不足4像素
+	assert.Equal(color.NRGBA{R: 127, G: 127, B: 127, A: 255}, grid.NRGBAAt(9, 9))
+
+	img := newStripeImage(10, 10)
+	result, err := NewPixelateImage(1)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(img, result)
+}
+
+func TestNewRedactImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newStripeImage(40, 40)
+	rects := []image.Rectangle{
+		image.Rect(0, 0, 10, 10),
+		image.Rect(30, 30, 50, 50),
+	}
+	for _, mode := range []string{RedactBlur, RedactPixelate, RedactFill} {
+		result, err := NewRedactImage(rects, RedactOptions{
+			Mode: mode,
+			Size: 4,
+		})(context.Background(), img)
+		assert.Nil(err)
+		assert.Equal(40, result.Width())
+		grid := imaging.Clone(result.grid)
+		// 区域外不受影响
+		assert.Equal(colorWhite, grid.NRGBAAt(20, 20), mode)
+		assert.Equal(colorBlack, grid.NRGBAAt(21, 20), mode)
+		// 区域内的条纹已被处理
+		assert.NotEqual(colorWhite, grid.NRGBAAt(4, 4), mode)
+		assert.NotEqual(colorWhite, grid.NRGBAAt(36, 36), mode)
+	}
+
+	red := color.NRGBA{R: 255, A: 255}
+	result, err := NewRedactImage(rects, RedactOptions{
+		Mode:  RedactFill,
+		Color: red,
+	})(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(red, imaging.Clone(result.grid).NRGBAAt(9, 9))
+
+	// 超出最大值
+	for _, mode := range []string{RedactBlur, RedactPixelate} {
+		_, err = NewRedactImage(rects, RedactOptions{
+			Mode: mode,
+			Size: 101,
+		})(context.Background(), img)
+		assert.True(errors.Is(err, ErrInvalidParam), mode)
+	}
+	_, err = NewPixelateImage(101)(context.Background(), img)
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
+
+func TestParseRedact(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("pixelate/4|redact/blur:5/0,0,10,10|redact/pixelate/0,0,10,10/20,20,5,5|redact/fill:red/0,0,5,5", "")
+	assert.Nil(err)
+	img, err := Do(context.Background(), newStripeImage(40, 40), jobs...)
+	assert.Nil(err)
+	assert.Equal(color.NRGBA{R: 255, A: 255}, imaging.Clone(img.grid).NRGBAAt(0, 0))
+
+	for _, task := range []string{
+		"pixelate",
+		"pixelate/a",
+		"redact/blur",
+		"redact/darken/0,0,10,10",
+		"redact/blur:a/0,0,10,10",
+		"redact/fill:xyz/0,0,10,10",
+		"redact/fill/0,0,10",
+		"redact/fill/0,0,10,a",
+		"redact/fill/0,0,0,10",
+		"pixelate/101",
+		"redact/blur:1000000/0,0,10,10",
+		"redact/pixelate:101/0,0,10,10",
+	} {
+		_, err = Parse(task, "")
+		assert.True(errors.Is(err, ErrInvalidParam), task)
+	}
+
+	SetMaxRedactSize(200)
+	defer SetMaxRedactSize(100)
+	_, err = Parse("redact/blur:150/0,0,10,10", "")
+	assert.Nil(err)
+}
@@ -17,6 +17,7 @@ package imagepipeline
 import (
 	"context"
 	"image"
+	"math"
 
 	"github.com/disintegration/imaging"
 )
@@ -30,14 +31,22 @@ func resize(fn resizeHandler, img *Image, width, height int) (*Image, error) {
 		return img, nil
 	}
 	grid := fn(img.grid, width, height, imaging.Lanczos)
-	img.Set(grid)
-	return img, nil
+	return img.WithGrid(grid), nil
 }
 
-// NewFitResizeImage creates an image job, which will resize the image to fit width/height
+// NewFitResizeImage creates an image job, which will resize the image to fit width/height,
+// 0 means no limit of width or height
 func NewFitResizeImage(width, height int) Job {
 	return func(_ context.Context, img *Image) (*Image, error) {
-		return resize(imaging.Fit, img, width, height)
+		w := width
+		h := height
+		if w <= 0 {
+			w = math.MaxInt32
+		}
+		if h <= 0 {
+			h = math.MaxInt32
+		}
+		return resize(imaging.Fit, img, w, h)
 	}
 }
 
@@ -50,3 +50,21 @@ func TestNewFillResizeImage(t *testing.T) {
 	assert.Equal(width, img.Width())
 	assert.Equal(height, img.Height())
 }
+
+func TestNewFitResizeImageNoLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = NewFitResizeImage(400, 0)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(400, img.Width())
+	assert.Equal(408, img.Height())
+
+	img, err = NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	img, err = NewFitResizeImage(0, 0)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(829, img.Width())
+	assert.Equal(846, img.Height())
+}
@@ -0,0 +1,219 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"math"
+
+	// 注册webp的解码，用于计算ssim
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	searchBySize = "size:"
+	searchBySSIM = "ssim:"
+)
+
+const (
+	defaultSearchAttempts = 7
+	ssimWindowSize        = 8
+)
+
+// SearchOptions is the options of searching quality,
+// one of MaxSize and MinSSIM should be set
+type SearchOptions struct {
+	// MaxSize is the max size of optimized data, the highest quality under it is searched
+	MaxSize int
+	// MinSSIM is the min SSIM(0-1) compared with the image, the lowest quality reaching it is searched
+	MinSSIM float64
+	// MinQuality and MaxQuality are the range of quality, default is 1-100
+	MinQuality int
+	MaxQuality int
+	// MaxAttempts is the max count of optimizing, default is 7
+	MaxAttempts int
+}
+
+func luminance(grid image.Image) []float64 {
+	bounds := grid.Bounds()
+	result := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgbaAt(grid, x, y)
+			result = append(result, 0.299*float64(c.R)+0.587*float64(c.G)+0.114*float64(c.B))
+		}
+	}
+	return result
+}
+
+// SSIM returns the mean structural similarity of the luminance of two images,
+// it is computed with 8x8 windows and returns 0 if the sizes are not equal
+func SSIM(a, b image.Image) float64 {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return 0
+	}
+	return ssimWithLuminance(luminance(a), b)
+}
+
+// ssimWithLuminance returns the SSIM of the luminance(computed before) and the image,
+// it returns 0 if the sizes are not equal
+func ssimWithLuminance(la []float64, b image.Image) float64 {
+	width := b.Bounds().Dx()
+	height := b.Bounds().Dy()
+	if len(la) != width*height || width == 0 || height == 0 {
+		return 0
+	}
+	c1 := math.Pow(0.01*255, 2)
+	c2 := math.Pow(0.03*255, 2)
+	lb := luminance(b)
+	total := 0.0
+	count := 0
+	for y := 0; y < height; y += ssimWindowSize {
+		for x := 0; x < width; x += ssimWindowSize {
+			maxX := int(math.Min(float64(x+ssimWindowSize), float64(width)))
+			maxY := int(math.Min(float64(y+ssimWindowSize), float64(height)))
+			n := float64((maxX - x) * (maxY - y))
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for j := y; j < maxY; j++ {
+				for i := x; i < maxX; i++ {
+					va := la[j*width+i]
+					vb := lb[j*width+i]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			meanA := sumA / n
+			meanB := sumB / n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			count++
+		}
+	}
+	return total / float64(count)
+}
+
+// NewSearchOptimizeImage creates an optimize image job, which binary-searches the quality
+// by optimizing repeatedly: the highest quality whose size is not larger than MaxSize,
+// or the lowest quality whose SSIM is not less than MinSSIM.
+// If no quality matches, the smallest data(MaxSize) or the most similar data(MinSSIM) is used.
+// If the format is nil, the original format will be used.
+func NewSearchOptimizeImage(optimizer Optimizer, opts SearchOptions, formats ...string) Job {
+	minQuality := opts.MinQuality
+	if minQuality <= 0 {
+		minQuality = 1
+	}
+	maxQuality := opts.MaxQuality
+	if maxQuality <= 0 || maxQuality > 100 {
+		maxQuality = 100
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultSearchAttempts
+	}
+	return func(ctx context.Context, img *Image) (*Image, error) {
+		if (opts.MaxSize <= 0) == (opts.MinSSIM <= 0) {
+			return nil, newInvalidParamError("one of max size and min ssim should be set")
+		}
+		if minQuality > maxQuality {
+			return nil, newInvalidParamError("min quality should not be greater than max quality")
+		}
+		format := img.format
+		if len(formats) != 0 {
+			format = formats[0]
+		}
+		// avif无法解码，不能计算ssim
+		if opts.MinSSIM > 0 && format == ImageTypeAVIF {
+			return nil, wrapError(ErrUnsupportedFormat, errors.New("ssim search does not support avif"))
+		}
+		source, sourceFormat, err := optimizeSource(img)
+		if err != nil {
+			return nil, err
+		}
+		// 原图的亮度只需计算一次
+		var sourceLuminance []float64
+		if opts.MinSSIM > 0 {
+			sourceLuminance = luminance(img.grid)
+		}
+		var matched, fallback []byte
+		matchedQuality := 0
+		fallbackQuality := 0
+		fallbackSSIM := -1.0
+		attempts := 0
+		low := minQuality
+		high := maxQuality
+		for attempts < maxAttempts && low <= high {
+			quality := (low + high) / 2
+			attempts++
+			data, err := optimizer.Optimize(ctx, &OptimizeParams{
+				Data:         source,
+				SourceFormat: sourceFormat,
+				Grid:         img.grid,
+				Quality:      quality,
+				Format:       format,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if opts.MaxSize > 0 {
+				if fallback == nil || len(data) < len(fallback) {
+					fallback = data
+					fallbackQuality = quality
+				}
+				if len(data) <= opts.MaxSize {
+					matched = data
+					matchedQuality = quality
+					low = quality + 1
+				} else {
+					high = quality - 1
+				}
+				continue
+			}
+			grid, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, wrapError(ErrUnsupportedFormat, err)
+			}
+			ssim := ssimWithLuminance(sourceLuminance, grid)
+			if ssim > fallbackSSIM {
+				fallback = data
+				fallbackQuality = quality
+				fallbackSSIM = ssim
+			}
+			if ssim >= opts.MinSSIM {
+				matched = data
+				matchedQuality = quality
+				high = quality - 1
+			} else {
+				low = quality + 1
+			}
+		}
+		if matched == nil {
+			matched = fallback
+			matchedQuality = fallbackQuality
+		}
+		return newOptimizedImage(ctx, img, source, sourceFormat, matched, format, nil, OptimizeStat{
+			Quality:  matchedQuality,
+			Attempts: attempts,
+		}), nil
+	}
+}
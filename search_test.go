@@ -0,0 +1,109 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSIM(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	assert.InDelta(1, SSIM(img.grid, img.grid), 0.0001)
+
+	low := bytes.Buffer{}
+	err = jpeg.Encode(&low, img.grid, &jpeg.Options{Quality: 5})
+	assert.Nil(err)
+	lowGrid, _, err := image.Decode(&low)
+	assert.Nil(err)
+	high := bytes.Buffer{}
+	err = jpeg.Encode(&high, img.grid, &jpeg.Options{Quality: 95})
+	assert.Nil(err)
+	highGrid, _, err := image.Decode(&high)
+	assert.Nil(err)
+	assert.True(SSIM(img.grid, lowGrid) < SSIM(img.grid, highGrid))
+
+	assert.Equal(0.0, SSIM(img.grid, newColorImage(10, 10, colorWhite).grid))
+	assert.Equal(SSIM(img.grid, lowGrid), ssimWithLuminance(luminance(img.grid), lowGrid))
+}
+
+func TestNewSearchOptimizeImage(t *testing.T) {
+	assert := assert.New(t)
+
+	optimizer, srv := newTestOptimServer(t)
+	srv.setEncode(true)
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	maxSize := 60 * 1024
+	result, report, err := DoWithReport(context.Background(), img, NewSearchOptimizeImage(optimizer, SearchOptions{
+		MaxSize: maxSize,
+	}))
+	assert.Nil(err)
+	assert.True(result.Size() <= maxSize)
+	stat := report.Steps[0].Optimize
+	assert.Equal(7, stat.Attempts)
+	assert.Equal(7, len(srv.qualities()))
+	// 更高一级的质量则超出限制
+	higher, err := NewOptimizeImage(optimizer, stat.Quality+1)(context.Background(), img)
+	assert.Nil(err)
+	assert.True(higher.Size() > maxSize)
+
+	minSSIM := 0.9
+	result, report, err = DoWithReport(context.Background(), img, NewSearchOptimizeImage(optimizer, SearchOptions{
+		MinSSIM:     minSSIM,
+		MaxAttempts: 3,
+	}))
+	assert.Nil(err)
+	stat = report.Steps[0].Optimize
+	assert.Equal(3, stat.Attempts)
+	data, _ := result.Bytes()
+	grid, _, err := image.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+	assert.True(SSIM(img.grid, grid) >= minSSIM)
+
+	_, err = NewSearchOptimizeImage(optimizer, SearchOptions{})(context.Background(), img)
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = NewSearchOptimizeImage(optimizer, SearchOptions{
+		MinSSIM: minSSIM,
+	}, ImageTypeAVIF)(context.Background(), img)
+	assert.True(errors.Is(err, ErrUnsupportedFormat))
+}
+
+func TestParseSearchOptimize(t *testing.T) {
+	assert := assert.New(t)
+
+	AddOptimizer("fake", &fakeOptimizer{})
+	defer optimizers.Delete("fake")
+	_, err := Parse("optimize/fake/size:100kb/webp", "")
+	assert.Nil(err)
+	_, err = Parse("optimize/fake/ssim:0.98", "")
+	assert.Nil(err)
+	_, err = Parse("optimize/fake/ssim:abc", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("optimize/fake/ssim:0.98/avif", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("optimize/fake/size:100kb/avif", "")
+	assert.Nil(err)
+}
@@ -0,0 +1,271 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vicanso/tiny/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+var ErrOptimizerClosed = errors.New("optimizer is closed")
+
+// ErrOptimizerTooLarge means the image is too large for the message size limit of optimizer,
+// it is categorized as ErrTooLarge
+var ErrOptimizerTooLarge = errors.New("image is too large for optimizer")
+
+const (
+	defaultTinyTimeout    = 30 * time.Second
+	defaultTinyMaxRetries = 2
+	defaultTinyBackoff    = 100 * time.Millisecond
+	// grpc默认的最大接收消息大小
+	defaultTinyMaxMessageSize = 4 * 1024 * 1024
+)
+
+// TinyOptions is the options of tiny optimizer
+type TinyOptions struct {
+	// Addrs are the addresses of tiny, the calls are balanced by round robin
+	Addrs []string
+	// TLS is the tls config, insecure credentials are used if it is nil
+	TLS *tls.Config
+	// Keepalive is the keepalive params of connection
+	Keepalive *keepalive.ClientParameters
+	// MaxMessageSize is the max size of message sent and received, default is 4MB(the default of grpc).
+	// The request larger than it fails with ErrOptimizerTooLarge before sending,
+	// so it should be the same as the limit of tiny server.
+	MaxMessageSize int
+	// Timeout is the timeout of each call if the context has no deadline, default is 30s
+	Timeout time.Duration
+	// MaxRetries is the max retries when the service is unavailable, default is 2, -1 means no retry
+	MaxRetries int
+	// Backoff is the backoff of first retry, it is doubled for each retry, default is 100ms
+	Backoff time.Duration
+	// DialOptions are the extra dial options
+	DialOptions []grpc.DialOption
+}
+
+type tinyOptimizer struct {
+	conns      []*grpc.ClientConn
+	index      uint32
+	closed     int32
+	timeout    time.Duration
+	maxRetries int
+	backoff    time.Duration
+	// maxMessageSize is the max size of message
+	maxMessageSize int
+	callOpts       []grpc.CallOption
+}
+
+// convertGRPCError converts the grpc status error to the category error
+func convertGRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return wrapError(ErrNotFound, err)
+	case codes.InvalidArgument:
+		return wrapError(ErrInvalidParam, err)
+	case codes.ResourceExhausted:
+		return wrapError(ErrTooLarge, fmt.Errorf("%w, %s", ErrOptimizerTooLarge, status.Convert(err).Message()))
+	case codes.Unimplemented:
+		return wrapError(ErrUnsupportedFormat, err)
+	}
+	return wrapError(ErrUpstream, err)
+}
+
+func convertToPBType(format string) pb.Type {
+	switch format {
+	case ImageTypeJPEG:
+		return pb.Type_JPEG
+	case ImageTypePNG:
+		return pb.Type_PNG
+	case ImageTypeWEBP:
+		return pb.Type_WEBP
+	case ImageTypeAVIF:
+		return pb.Type_AVIF
+	}
+	return pb.Type_UNKNOWN
+}
+
+// NewTinyOptimizer returns an optimizer which uses the tiny grpc services,
+// the connections are created with the options and kept until Close
+func NewTinyOptimizer(opts TinyOptions) (Optimizer, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("tiny addrs can not be nil")
+	}
+	dialOpts := make([]grpc.DialOption, 0, len(opts.DialOptions)+2)
+	if opts.TLS != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(opts.TLS)))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if opts.Keepalive != nil {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(*opts.Keepalive))
+	}
+	dialOpts = append(dialOpts, opts.DialOptions...)
+
+	to := &tinyOptimizer{
+		timeout:    opts.Timeout,
+		maxRetries: opts.MaxRetries,
+		backoff:    opts.Backoff,
+	}
+	if to.timeout <= 0 {
+		to.timeout = defaultTinyTimeout
+	}
+	if to.maxRetries == 0 {
+		to.maxRetries = defaultTinyMaxRetries
+	}
+	if to.backoff <= 0 {
+		to.backoff = defaultTinyBackoff
+	}
+	to.maxMessageSize = opts.MaxMessageSize
+	if to.maxMessageSize <= 0 {
+		to.maxMessageSize = defaultTinyMaxMessageSize
+	}
+	to.callOpts = []grpc.CallOption{
+		grpc.MaxCallSendMsgSize(to.maxMessageSize),
+		grpc.MaxCallRecvMsgSize(to.maxMessageSize),
+	}
+	for _, addr := range opts.Addrs {
+		// 连接为非阻塞，调用时才会真正建立
+		conn, err := grpc.Dial(addr, dialOpts...)
+		if err != nil {
+			_ = to.Close(context.Background())
+			return nil, err
+		}
+		to.conns = append(to.conns, conn)
+	}
+	return to, nil
+}
+
+func (to *tinyOptimizer) nextConn() *grpc.ClientConn {
+	index := atomic.AddUint32(&to.index, 1)
+	return to.conns[int(index)%len(to.conns)]
+}
+
+func (to *tinyOptimizer) Optimize(ctx context.Context, params *OptimizeParams) ([]byte, error) {
+	if atomic.LoadInt32(&to.closed) == 1 {
+		return nil, wrapError(ErrUpstream, ErrOptimizerClosed)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, to.timeout)
+		defer cancel()
+	}
+	in := pb.OptimRequest{
+		Data:    params.Data,
+		Source:  convertToPBType(params.SourceFormat),
+		Quality: uint32(params.Quality),
+		Output:  convertToPBType(params.Format),
+	}
+	if in.Output == pb.Type_UNKNOWN {
+		in.Output = pb.Type_JPEG
+	}
+	// tiny不支持流式上传，因此超出限制的直接返回出错
+	if size := in.Size(); size > to.maxMessageSize {
+		return nil, wrapError(ErrTooLarge, fmt.Errorf("%w, size:%d, limit:%d", ErrOptimizerTooLarge, size, to.maxMessageSize))
+	}
+	backoff := to.backoff
+	for retries := 0; ; retries++ {
+		client := pb.NewOptimClient(to.nextConn())
+		reply, err := client.DoOptim(ctx, &in, to.callOpts...)
+		if err == nil {
+			return reply.Data, nil
+		}
+		// 仅服务不可用时重试(下一次使用其它的地址)
+		if status.Code(err) != codes.Unavailable || retries >= to.maxRetries {
+			return nil, convertGRPCError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, convertGRPCError(err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Close closes the connections
+func (to *tinyOptimizer) Close(_ context.Context) error {
+	atomic.StoreInt32(&to.closed, 1)
+	var result error
+	for _, conn := range to.conns {
+		err := conn.Close()
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// AddTinyOptimizer adds a tiny grpc optimizer
+func AddTinyOptimizer(name string, opts TinyOptions) error {
+	o, err := NewTinyOptimizer(opts)
+	if err != nil {
+		return err
+	}
+	AddOptimizer(name, o)
+	return nil
+}
+
+// allowedOptimizerAddrs are the raw addresses of tiny which can be used in the pipeline task
+var allowedOptimizerAddrs = sync.Map{}
+
+// AllowOptimizerAddrs allows the raw addresses of tiny to be used in the pipeline task,
+// e.g. `optimize/192.168.1.1:6002/80`. Only the registered optimizers can be used by default.
+func AllowOptimizerAddrs(addrs ...string) {
+	for _, addr := range addrs {
+		allowedOptimizerAddrs.Store(addr, true)
+	}
+}
+
+func isOptimizerAddrAllowed(addr string) bool {
+	_, ok := allowedOptimizerAddrs.Load(addr)
+	return ok
+}
+
+// addrOptimizers are the tiny optimizers of raw address, which are created by the pipeline task
+var addrOptimizers = sync.Map{}
+
+// getAddrOptimizer returns the tiny optimizer of address, it is created once and reused
+func getAddrOptimizer(addr string) (Optimizer, error) {
+	value, ok := addrOptimizers.Load(addr)
+	if ok {
+		return value.(Optimizer), nil
+	}
+	o, err := NewTinyOptimizer(TinyOptions{
+		Addrs: []string{
+			addr,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, loaded := addrOptimizers.LoadOrStore(addr, o)
+	if loaded {
+		_ = o.Close(context.Background())
+	}
+	return value.(Optimizer), nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/tiny/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testOptimServer records the request and returns the half of data,
+// or the doubled data if grow is true, or the jpeg data of quality if encode is true
+type testOptimServer struct {
+	mutex sync.Mutex
+	grow  bool
+	// encode the data to jpeg with quality
+	encode bool
+	// unavailable is the count of unavailable errors returned before success
+	unavailable int
+	delay       time.Duration
+	requests    []*pb.OptimRequest
+}
+
+func (s *testOptimServer) DoOptim(_ context.Context, in *pb.OptimRequest) (*pb.OptimReply, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, in)
+	if s.delay != 0 {
+		time.Sleep(s.delay)
+	}
+	if s.unavailable > 0 {
+		s.unavailable--
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	data := in.Data[:len(in.Data)/2]
+	if s.grow {
+		data = append(append([]byte{}, in.Data...), in.Data...)
+	}
+	if s.encode {
+		img, _, err := image.Decode(bytes.NewReader(in.Data))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		buf := bytes.Buffer{}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{
+			Quality: int(in.Quality),
+		})
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		data = buf.Bytes()
+	}
+	return &pb.OptimReply{
+		Output: in.Output,
+		Data:   data,
+	}, nil
+}
+
+func (s *testOptimServer) setGrow(grow bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.grow = grow
+}
+
+func (s *testOptimServer) setEncode(encode bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.encode = encode
+}
+
+func (s *testOptimServer) setUnavailable(count int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unavailable = count
+}
+
+func (s *testOptimServer) setDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delay = delay
+}
+
+func (s *testOptimServer) qualities() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]int, len(s.requests))
+	for i, in := range s.requests {
+		result[i] = int(in.Quality)
+	}
+	return result
+}
+
+func (s *testOptimServer) lastRequest() *pb.OptimRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// newBufconnDialer starts the servers in process, and returns the dial option
+// which dials the server by address
+func newBufconnDialer(t *testing.T, servers map[string]pb.OptimServer, serverOpts ...grpc.ServerOption) grpc.DialOption {
+	listeners := make(map[string]*bufconn.Listener)
+	for addr, srv := range servers {
+		ln := bufconn.Listen(1024 * 1024)
+		s := grpc.NewServer(serverOpts...)
+		pb.RegisterOptimServer(s, srv)
+		go func() {
+			_ = s.Serve(ln)
+		}()
+		t.Cleanup(s.Stop)
+		listeners[addr] = ln
+	}
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		ln, ok := listeners[addr]
+		if !ok {
+			return nil, errors.New("address is not found: " + addr)
+		}
+		return ln.DialContext(ctx)
+	})
+}
+
+// newTestOptimServer starts a tiny server in process, and returns the optimizer of it
+func newTestOptimServer(t *testing.T) (Optimizer, *testOptimServer) {
+	srv := &testOptimServer{}
+	optimizer, err := NewTinyOptimizer(TinyOptions{
+		Addrs: []string{
+			"bufnet",
+		},
+		DialOptions: []grpc.DialOption{
+			newBufconnDialer(t, map[string]pb.OptimServer{
+				"bufnet": srv,
+			}),
+		},
+	})
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = optimizer.Close(context.Background())
+	})
+	return optimizer, srv
+}
+
+func TestConvertGRPCError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(errors.Is(convertGRPCError(status.Error(codes.ResourceExhausted, "message too large")), ErrTooLarge))
+	assert.True(errors.Is(convertGRPCError(status.Error(codes.ResourceExhausted, "message too large")), ErrOptimizerTooLarge))
+	assert.True(errors.Is(convertGRPCError(status.Error(codes.InvalidArgument, "quality is invalid")), ErrInvalidParam))
+	assert.True(errors.Is(convertGRPCError(status.Error(codes.Unavailable, "connection refused")), ErrUpstream))
+	assert.True(errors.Is(convertGRPCError(errors.New("unknown")), ErrUpstream))
+}
+
+func TestTinyOptimizer(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewTinyOptimizer(TinyOptions{})
+	assert.NotNil(err)
+
+	a := &testOptimServer{}
+	b := &testOptimServer{}
+	optimizer, err := NewTinyOptimizer(TinyOptions{
+		Addrs: []string{
+			"a",
+			"b",
+		},
+		Timeout: 100 * time.Millisecond,
+		Backoff: time.Millisecond,
+		DialOptions: []grpc.DialOption{
+			newBufconnDialer(t, map[string]pb.OptimServer{
+				"a": a,
+				"b": b,
+			}),
+		},
+	})
+	assert.Nil(err)
+	params := &OptimizeParams{
+		Data:         newImageData(),
+		SourceFormat: ImageTypeJPEG,
+		Quality:      80,
+		Format:       ImageTypeWEBP,
+	}
+
+	// 轮询使用各地址
+	for i := 0; i < 4; i++ {
+		data, err := optimizer.Optimize(context.Background(), params)
+		assert.Nil(err)
+		assert.Equal(len(newImageData())/2, len(data))
+	}
+	assert.Equal(2, len(a.qualities()))
+	assert.Equal(2, len(b.qualities()))
+
+	// 服务不可用时重试
+	a.setUnavailable(1)
+	b.setUnavailable(1)
+	_, err = optimizer.Optimize(context.Background(), params)
+	assert.Nil(err)
+	// 4次成功的请求 + 2次不可用 + 1次成功
+	assert.Equal(7, len(a.qualities())+len(b.qualities()))
+
+	a.setUnavailable(3)
+	b.setUnavailable(3)
+	_, err = optimizer.Optimize(context.Background(), params)
+	assert.True(errors.Is(err, ErrUpstream))
+	assert.Equal(codes.Unavailable, status.Code(errors.Unwrap(err)))
+	a.setUnavailable(0)
+	b.setUnavailable(0)
+
+	// 超时
+	a.setDelay(200 * time.Millisecond)
+	b.setDelay(200 * time.Millisecond)
+	_, err = optimizer.Optimize(context.Background(), params)
+	assert.True(errors.Is(err, ErrUpstream))
+	assert.Equal(codes.DeadlineExceeded, status.Code(errors.Unwrap(err)))
+
+	assert.Nil(optimizer.Close(context.Background()))
+	_, err = optimizer.Optimize(context.Background(), params)
+	assert.True(errors.Is(err, ErrOptimizerClosed))
+}
+
+func TestTinyOptimizerMaxMessageSize(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := &testOptimServer{}
+	newOptimizer := func(maxMessageSize int) Optimizer {
+		optimizer, err := NewTinyOptimizer(TinyOptions{
+			Addrs: []string{
+				"bufnet",
+			},
+			MaxMessageSize: maxMessageSize,
+			DialOptions: []grpc.DialOption{
+				newBufconnDialer(t, map[string]pb.OptimServer{
+					"bufnet": srv,
+				}, grpc.MaxRecvMsgSize(64*1024)),
+			},
+		})
+		assert.Nil(err)
+		t.Cleanup(func() {
+			_ = optimizer.Close(context.Background())
+		})
+		return optimizer
+	}
+	params := &OptimizeParams{
+		Data:         newImageData(),
+		SourceFormat: ImageTypeJPEG,
+		Format:       ImageTypeWEBP,
+	}
+
+	// 发送前检查数据大小
+	_, err := newOptimizer(1024).Optimize(context.Background(), params)
+	assert.True(errors.Is(err, ErrTooLarge))
+	assert.True(errors.Is(err, ErrOptimizerTooLarge))
+	assert.Contains(err.Error(), "limit:1024")
+	assert.Equal(0, len(srv.qualities()))
+
+	_, err = newOptimizer(0).Optimize(context.Background(), &OptimizeParams{
+		Data:   make([]byte, 5*1024*1024),
+		Format: ImageTypeWEBP,
+	})
+	assert.True(errors.Is(err, ErrOptimizerTooLarge))
+	assert.Equal(0, len(srv.qualities()))
+
+	// 超出服务端的限制
+	_, err = newOptimizer(0).Optimize(context.Background(), params)
+	assert.True(errors.Is(err, ErrTooLarge))
+	assert.True(errors.Is(err, ErrOptimizerTooLarge))
+}
+
+func TestGetAddrOptimizer(t *testing.T) {
+	assert := assert.New(t)
+
+	o1, err := getAddrOptimizer("127.0.0.1:6002")
+	assert.Nil(err)
+	o2, err := getAddrOptimizer("127.0.0.1:6002")
+	assert.Nil(err)
+	assert.Equal(o1, o2)
+
+	assert.Nil(CloseOptimizers(context.Background()))
+	_, ok := addrOptimizers.Load("127.0.0.1:6002")
+	assert.False(ok)
+}
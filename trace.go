@@ -0,0 +1,214 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ImageInfo is the summary of image
+type ImageInfo struct {
+	Width  int
+	Height int
+	// Size is the size of encoded data, it is 0 if the image is changed and not encoded
+	Size   int
+	Format string
+}
+
+// JobStep is the execution info of a job
+type JobStep struct {
+	// Index is the index of job in pipeline
+	Index int
+	// Name is the name of job(NewNamedJob), it is empty if the job is not named
+	Name      string
+	StartedAt time.Time
+	Duration  time.Duration
+	Input     ImageInfo
+	Output    ImageInfo
+	Err       error
+	// Optimize is the stat of optimize job, it is nil for other jobs
+	Optimize *OptimizeStat
+	// Parent is the step of the job which runs this job in a nested pipeline
+	// (e.g. fallback, composite, montage), it is nil for the top-level job
+	Parent *JobStep
+	// Depth is the nested depth of job, it is 0 for the top-level job
+	Depth int
+	// Children are the steps of the nested pipelines, it is only filled by DoWithReport
+	Children []*JobStep
+
+	observers []Observer
+}
+
+// Report is the execution report of pipeline,
+// the steps are the top-level jobs, and the nested jobs are in their children
+type Report struct {
+	Steps    []*JobStep
+	Duration time.Duration
+}
+
+// Observer observes the jobs of pipeline, OnJobStart is called before the job runs
+// and OnJobEnd is called after the job is done, the jobs of nested pipelines are also observed.
+type Observer interface {
+	OnJobStart(ctx context.Context, step *JobStep)
+	OnJobEnd(ctx context.Context, step *JobStep)
+}
+
+type observerKey struct{}
+type jobStepKey struct{}
+
+func newImageInfo(img *Image) ImageInfo {
+	if img == nil || img.grid == nil {
+		return ImageInfo{}
+	}
+	return ImageInfo{
+		Width:  img.Width(),
+		Height: img.Height(),
+		Size:   len(img.optimizedData),
+		Format: img.format,
+	}
+}
+
+// WithObserver returns a copy of ctx with the observers,
+// the observers will be notified when the jobs of Do run
+func WithObserver(ctx context.Context, observers ...Observer) context.Context {
+	result := append([]Observer{}, getObservers(ctx)...)
+	result = append(result, observers...)
+	return context.WithValue(ctx, observerKey{}, result)
+}
+
+func getObservers(ctx context.Context) []Observer {
+	observers, _ := ctx.Value(observerKey{}).([]Observer)
+	return observers
+}
+
+func getJobStep(ctx context.Context) *JobStep {
+	step, _ := ctx.Value(jobStepKey{}).(*JobStep)
+	return step
+}
+
+func (s *JobStep) start(ctx context.Context) {
+	for _, o := range s.observers {
+		o.OnJobStart(ctx, s)
+	}
+}
+
+func (s *JobStep) end(ctx context.Context, img *Image, err error) {
+	s.Duration = time.Since(s.StartedAt)
+	s.Output = newImageInfo(img)
+	s.Err = err
+	for _, o := range s.observers {
+		o.OnJobEnd(ctx, s)
+	}
+}
+
+type namedJob struct {
+	name string
+	fn   Job
+}
+
+type jobNameKey struct{}
+
+// run runs the job, it only returns the name if the context is for getting name
+func (nj *namedJob) run(ctx context.Context, img *Image) (*Image, error) {
+	if name, ok := ctx.Value(jobNameKey{}).(*string); ok {
+		*name = nj.name
+		return nil, nil
+	}
+	step := getJobStep(ctx)
+	// 被其它job包装时无法预先获取名称，在执行时设置
+	if step != nil && step.Name == "" {
+		step.Name = nj.name
+	}
+	return nj.fn(ctx, img)
+}
+
+// 所有named job的函数地址均相同，用于判断job是否named job
+var namedJobPointer = reflect.ValueOf((&namedJob{}).run).Pointer()
+
+// NewNamedJob creates a job with name, the name is attached to the job step of observer
+// before the job starts
+func NewNamedJob(name string, fn Job) Job {
+	nj := &namedJob{
+		name: name,
+		fn:   fn,
+	}
+	return nj.run
+}
+
+// getJobName returns the name of job created by NewNamedJob, it returns empty for other jobs
+func getJobName(fn Job) string {
+	if fn == nil || reflect.ValueOf(fn).Pointer() != namedJobPointer {
+		return ""
+	}
+	name := ""
+	_, _ = fn(context.WithValue(context.Background(), jobNameKey{}, &name), nil)
+	return name
+}
+
+func doJob(ctx context.Context, index int, fn Job, img *Image) (*Image, error) {
+	step := &JobStep{
+		Index:     index,
+		Name:      getJobName(fn),
+		StartedAt: time.Now(),
+		Input:     newImageInfo(img),
+		Parent:    getJobStep(ctx),
+		observers: getObservers(ctx),
+	}
+	if step.Parent != nil {
+		step.Depth = step.Parent.Depth + 1
+	}
+	ctx = context.WithValue(ctx, jobStepKey{}, step)
+	step.start(ctx)
+	result, err := fn(ctx, img)
+	if err != nil && err != ErrAbortNext {
+		err = withTask(err, index, step.Name)
+	}
+	step.end(ctx, result, err)
+	return result, err
+}
+
+type reportObserver struct {
+	mutex  sync.Mutex
+	report *Report
+}
+
+func (ro *reportObserver) OnJobStart(_ context.Context, _ *JobStep) {}
+
+func (ro *reportObserver) OnJobEnd(_ context.Context, step *JobStep) {
+	ro.mutex.Lock()
+	defer ro.mutex.Unlock()
+	// 嵌套的任务添加至其父任务中
+	if step.Parent != nil {
+		step.Parent.Children = append(step.Parent.Children, step)
+		return
+	}
+	ro.report.Steps = append(ro.report.Steps, step)
+}
+
+// DoWithReport runs the pipeline jobs, and returns the execution report
+func DoWithReport(ctx context.Context, img *Image, jobs ...Job) (*Image, *Report, error) {
+	report := &Report{
+		Steps: make([]*JobStep, 0, len(jobs)),
+	}
+	startedAt := time.Now()
+	img, err := Do(WithObserver(ctx, &reportObserver{
+		report: report,
+	}), img, jobs...)
+	report.Duration = time.Since(startedAt)
+	return img, report, err
+}
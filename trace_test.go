@@ -0,0 +1,153 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testObserver struct {
+	starts []string
+	ends   []string
+}
+
+func (to *testObserver) OnJobStart(_ context.Context, step *JobStep) {
+	to.starts = append(to.starts, step.Name)
+}
+
+func (to *testObserver) OnJobEnd(_ context.Context, step *JobStep) {
+	to.ends = append(to.ends, step.Name)
+}
+
+func TestObserver(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	o := &testObserver{}
+	ctx := WithObserver(context.Background(), o)
+	startCount := 0
+	_, err = Do(ctx, img,
+		NewNamedJob(TaskFitResize, NewFitResizeImage(400, 300)),
+		func(ctx context.Context, img *Image) (*Image, error) {
+			// start在任务执行前触发
+			startCount = len(o.starts)
+			return NewFillResizeImage(100, 100)(ctx, img)
+		},
+	)
+	assert.Nil(err)
+	assert.Equal(2, startCount)
+	assert.Equal([]string{TaskFitResize, ""}, o.starts)
+	assert.Equal([]string{TaskFitResize, ""}, o.ends)
+}
+
+func TestDoWithReport(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+
+	jobs, err := Parse("fitResize/400/300|fillResize/100/100", "")
+	assert.Nil(err)
+
+	img, report, err := DoWithReport(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(100, img.Width())
+	assert.Equal(2, len(report.Steps))
+
+	step := report.Steps[0]
+	assert.Equal(0, step.Index)
+	assert.Equal(TaskFitResize, step.Name)
+	assert.Equal(ImageInfo{
+		Width:  829,
+		Height: 846,
+		Size:   len(newImageData()),
+		Format: ImageTypeJPEG,
+	}, step.Input)
+	assert.Equal(293, step.Output.Width)
+	assert.Equal(300, step.Output.Height)
+	assert.Equal(0, step.Output.Size)
+
+	step = report.Steps[1]
+	assert.Equal(1, step.Index)
+	assert.Equal(TaskFillResize, step.Name)
+	assert.Equal(100, step.Output.Width)
+	assert.True(report.Duration >= step.Duration)
+
+	// 出错的任务也会记录
+	customErr := errors.New("custom error")
+	_, report, err = DoWithReport(context.Background(), img, NewNamedJob("custom", func(_ context.Context, _ *Image) (*Image, error) {
+		return nil, customErr
+	}))
+	assert.True(errors.Is(err, customErr))
+	assert.Equal("task[0] custom: custom error", err.Error())
+	assert.Equal(1, len(report.Steps))
+	assert.Equal(err, report.Steps[0].Err)
+
+	// 嵌套的任务记录在父任务中
+	jobs, err = Parse("fallback(fitResize/400/300|fillResize/100/100)|fitResize/50/50", "")
+	assert.Nil(err)
+	_, report, err = DoWithReport(context.Background(), img, jobs...)
+	assert.Nil(err)
+	assert.Equal(2, len(report.Steps))
+	step = report.Steps[0]
+	assert.Equal(0, step.Index)
+	assert.Equal(TaskFallback, step.Name)
+	assert.Equal(0, step.Depth)
+	assert.Nil(step.Parent)
+	assert.Equal(2, len(step.Children))
+	for index, child := range step.Children {
+		assert.Equal(index, child.Index)
+		assert.Equal(1, child.Depth)
+		assert.Equal(step, child.Parent)
+	}
+	assert.Equal(TaskFitResize, step.Children[0].Name)
+	assert.Equal(TaskFillResize, step.Children[1].Name)
+	assert.Equal(1, report.Steps[1].Index)
+	assert.Equal(TaskFitResize, report.Steps[1].Name)
+}
+
+func TestGetJobName(t *testing.T) {
+	assert := assert.New(t)
+
+	called := false
+	fn := func(_ context.Context, img *Image) (*Image, error) {
+		called = true
+		return img, nil
+	}
+	// 非named job不会被执行
+	assert.Equal("", getJobName(fn))
+	assert.False(called)
+
+	assert.Equal("a", getJobName(NewNamedJob("a", fn)))
+	assert.Equal("a", getJobName(NewNamedJob("a", NewNamedJob("b", fn))))
+	assert.False(called)
+
+	// 被包装的named job在执行时设置名称
+	o := &testObserver{}
+	named := NewNamedJob("a", fn)
+	_, err := Do(WithObserver(context.Background(), o), nil, func(ctx context.Context, img *Image) (*Image, error) {
+		return named(ctx, img)
+	})
+	assert.Nil(err)
+	assert.True(called)
+	assert.Equal([]string{""}, o.starts)
+	assert.Equal([]string{"a"}, o.ends)
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+func colorDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// colorMatch returns true if the max difference of channels is not greater than tolerance
+func colorMatch(pix []uint8, c color.NRGBA, tolerance int) bool {
+	// 均为透明时不判断颜色
+	if pix[3] == 0 && c.A == 0 {
+		return true
+	}
+	return colorDiff(pix[0], c.R) <= tolerance &&
+		colorDiff(pix[1], c.G) <= tolerance &&
+		colorDiff(pix[2], c.B) <= tolerance &&
+		colorDiff(pix[3], c.A) <= tolerance
+}
+
+// detectBorderColor returns the color of corners, false will be returned if the corners are not uniform
+func detectBorderColor(grid *image.NRGBA, tolerance int) (color.NRGBA, bool) {
+	r := grid.Bounds()
+	if r.Empty() {
+		return color.NRGBA{}, false
+	}
+	c := grid.NRGBAAt(r.Min.X, r.Min.Y)
+	for _, pt := range []image.Point{
+		{r.Max.X - 1, r.Min.Y},
+		{r.Min.X, r.Max.Y - 1},
+		{r.Max.X - 1, r.Max.Y - 1},
+	} {
+		i := grid.PixOffset(pt.X, pt.Y)
+		if !colorMatch(grid.Pix[i:i+4], c, tolerance) {
+			return c, false
+		}
+	}
+	return c, true
+}
+
+// trimBounds returns the bounds of content which is not the border color
+func trimBounds(grid *image.NRGBA, c color.NRGBA, tolerance int) image.Rectangle {
+	r := grid.Bounds()
+	result := image.Rectangle{}
+	found := false
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := grid.PixOffset(x, y)
+			if colorMatch(grid.Pix[i:i+4], c, tolerance) {
+				continue
+			}
+			pt := image.Rect(x, y, x+1, y+1)
+			if !found {
+				result = pt
+				found = true
+			} else {
+				result = result.Union(pt)
+			}
+		}
+	}
+	return result
+}
+
+// NewTrimImage creates a job, which trims the uniform border of image,
+// the border color is detected from the corners if the color is nil
+func NewTrimImage(tolerance int, borderColor *color.NRGBA) Job {
+	return func(_ context.Context, img *Image) (*Image, error) {
+		// 空图片无边框可处理
+		if img.grid.Bounds().Empty() {
+			return img, nil
+		}
+		grid := imaging.Clone(img.grid)
+		var c color.NRGBA
+		if borderColor != nil {
+			c = *borderColor
+		} else {
+			var ok bool
+			c, ok = detectBorderColor(grid, tolerance)
+			// 四个角颜色不一致，无需处理
+			if !ok {
+				return img, nil
+			}
+		}
+		r := trimBounds(grid, c, tolerance)
+		// 全部为边框颜色或无边框
+		if r.Empty() || r.Eq(grid.Bounds()) {
+			return img, nil
+		}
+		return img.WithGrid(imaging.Crop(grid, r)), nil
+	}
+}
+
+func parseTrim(params []string, _ string) (Job, error) {
+	tolerance := 0
+	if len(params) > 0 && params[0] != "" {
+		v, err := strconv.Atoi(params[0])
+		if err != nil {
+			return nil, err
+		}
+		tolerance = v
+	}
+	if len(params) > 1 && params[1] != "" {
+		c, err := ParseColor(params[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewTrimImage(tolerance, &c), nil
+	}
+	return NewTrimImage(tolerance, nil), nil
+}
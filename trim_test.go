@@ -0,0 +1,114 @@
+// Copyright 2022 tree xie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imagepipeline
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTrimTestImage() *Image {
+	// 100x80的白色背景，中间为30x20的黑色内容
+	canvas := imaging.New(100, 80, colorWhite)
+	content := imaging.New(30, 20, colorBlack)
+	return &Image{
+		grid:   imaging.Paste(canvas, content, image.Pt(10, 20)),
+		format: ImageTypePNG,
+	}
+}
+
+func TestNewTrimImage(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTrimTestImage()
+	result, err := NewTrimImage(0, nil)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(30, result.Width())
+	assert.Equal(20, result.Height())
+
+	// 接近白色的噪点在容差范围内
+	grid := imaging.Clone(img.grid)
+	grid.SetNRGBA(90, 70, color.NRGBA{R: 250, G: 250, B: 250, A: 255})
+	noisy := &Image{
+		grid: grid,
+	}
+	result, err = NewTrimImage(0, nil)(context.Background(), noisy)
+	assert.Nil(err)
+	assert.Equal(81, result.Width())
+	result, err = NewTrimImage(10, nil)(context.Background(), noisy)
+	assert.Nil(err)
+	assert.Equal(30, result.Width())
+
+	// 指定颜色
+	result, err = NewTrimImage(0, &colorBlack)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(img, result)
+	result, err = NewTrimImage(0, &colorWhite)(context.Background(), img)
+	assert.Nil(err)
+	assert.Equal(30, result.Width())
+
+	// 四个角颜色不一致
+	grid = imaging.Clone(img.grid)
+	grid.SetNRGBA(0, 0, colorBlack)
+	mixed := &Image{
+		grid: grid,
+	}
+	result, err = NewTrimImage(0, nil)(context.Background(), mixed)
+	assert.Nil(err)
+	assert.Equal(mixed, result)
+
+	// 纯色图片
+	plain := newColorImage(10, 10, colorWhite)
+	result, err = NewTrimImage(0, nil)(context.Background(), plain)
+	assert.Nil(err)
+	assert.Equal(plain, result)
+
+	// 空图片
+	empty := newColorImage(0, 0, colorWhite)
+	result, err = NewTrimImage(0, nil)(context.Background(), empty)
+	assert.Nil(err)
+	assert.Equal(empty, result)
+	_, ok := detectBorderColor(image.NewNRGBA(image.Rect(0, 0, 0, 0)), 0)
+	assert.False(ok)
+}
+
+func TestParseTrim(t *testing.T) {
+	assert := assert.New(t)
+
+	jobs, err := Parse("trim/10/white|fitResize/15/0", "")
+	assert.Nil(err)
+	img, err := Do(context.Background(), newTrimTestImage(), jobs...)
+	assert.Nil(err)
+	assert.Equal(15, img.Width())
+	assert.Equal(10, img.Height())
+
+	jobs, err = Parse("fillResize/0/100|trim", "")
+	assert.Nil(err)
+	_, err = Do(context.Background(), newTrimTestImage(), jobs...)
+	assert.Nil(err)
+
+	_, err = Parse("trim", "")
+	assert.Nil(err)
+	_, err = Parse("trim/abc", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+	_, err = Parse("trim/10/xyz", "")
+	assert.True(errors.Is(err, ErrInvalidParam))
+}
@@ -53,17 +53,16 @@ func getWatermarkPosition(position string, w, h, watermarkWidth, watermarkHeight
 
 // NewWatermark creates an image job, which will add watermark to image
 func NewWatermark(watermarkImg image.Image, position string, angle float64) Job {
+	if angle != 0 {
+		watermarkImg = imaging.Rotate(watermarkImg, angle, color.Transparent)
+	}
 	return func(_ context.Context, img *Image) (*Image, error) {
-		if angle != 0 {
-			watermarkImg = imaging.Rotate(watermarkImg, angle, color.Transparent)
-		}
 		w := img.Width()
 		h := img.Height()
 		watermarkWidth := watermarkImg.Bounds().Dx()
 		watermarkHeight := watermarkImg.Bounds().Dy()
 		x, y := getWatermarkPosition(position, w, h, watermarkWidth, watermarkHeight)
 		grid := imaging.Paste(img.grid, watermarkImg, image.Pt(x, y))
-		img.Set(grid)
-		return img, nil
+		return img.WithGrid(grid), nil
 	}
 }
@@ -16,6 +16,7 @@ package imagepipeline
 
 import (
 	"context"
+	"image"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -84,3 +85,17 @@ func TestNewWatermark(t *testing.T) {
 	assert.Equal(829, img.Width())
 	assert.Equal(846, img.Height())
 }
+
+func TestNewWatermarkRotate(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := NewImageFromBytes(newImageData())
+	assert.Nil(err)
+	fn := NewWatermark(image.NewNRGBA(image.Rect(0, 0, 100, 10)), PositionCenter, 90)
+	// 多次执行时水印不会重复旋转
+	for i := 0; i < 2; i++ {
+		result, err := fn(context.Background(), img)
+		assert.Nil(err)
+		assert.Equal(829, result.Width())
+	}
+}